@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"github.com/kolah/runner/internal/app"
+	"github.com/kolah/runner/internal/app/config"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Watches files and sends changes to a runner using the remote watch backend",
+
+	Run: func(cmd *cobra.Command, args []string) {
+		configuration, err := config.LoadConfig(cmd)
+		if err != nil {
+			log.Fatal("Failed to load config: " + err.Error())
+		}
+
+		logger, err := config.ConfigureLogging(configuration.Logging)
+		if err != nil {
+			log.Fatal("Failed to configure logger: ", err.Error())
+		}
+
+		name, _ := cmd.Flags().GetString("backend")
+		backend, err := app.ParseWatchBackend(name)
+		if err != nil || backend == app.BackendRemote {
+			log.Fatal("Invalid backend, expected fsnotify, poll or auto: ", name)
+		}
+
+		watcherOptions := app.NewWatcherOptions(backend, configuration.Watch.PollInterval, configuration.Watch.PollOnLimit, "", false, configuration.Watch.FollowSymlinks)
+		watch := app.NewWatcher(configuration.Watch.Directories, configuration.Watch.IgnoredDirectories, configuration.Watch.WatchPatterns, configuration.Watch.IgnoredFiles, watcherOptions, logger)
+
+		address, _ := cmd.Flags().GetString("connect")
+		contents, _ := cmd.Flags().GetBool("contents")
+		agent, err := app.NewAgent(address, watch, contents, logger)
+		if err != nil {
+			log.Fatal("Invalid address: ", err.Error())
+		}
+
+		logger.Infof("Sending changes to %s\n", address)
+		if err := agent.Start(); err != nil {
+			logger.Infof("Fatal error while starting agent %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+		sig := <-sigc
+		logger.Debugf("Caught signal %s: shutting down\n", sig)
+
+		if err := agent.Stop(); err != nil {
+			logger.Debugf("Failed to stop agent: %s\n", err.Error())
+		}
+	},
+}
+
+func init() {
+	agentCmd.Flags().String("connect", "tcp://localhost:55556", "address of the runner, tcp://host:port or unix:///path/to/socket")
+	agentCmd.Flags().Bool("contents", false, "send file contents, for runners that don't share the project directory")
+	agentCmd.Flags().String("backend", "fsnotify", "how the agent detects changes: fsnotify, poll or auto")
+}
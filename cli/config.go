@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/kolah/runner/internal/app/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"text/tabwriter"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config [validate|show]",
+	Short: "Validates and inspects the configuration",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Checks the configuration for unknown keys, invalid values and missing commands",
+	Args:  cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := config.Read(cmd); err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), "error:", err.Error())
+			os.Exit(1)
+		}
+
+		problems, err := config.Validate()
+		if err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), "error:", err.Error())
+			os.Exit(1)
+		}
+
+		failed := false
+		for _, problem := range problems {
+			//noinspection ALL
+			fmt.Fprintln(cmd.OutOrStdout(), problem)
+			failed = failed || !problem.Warning
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+		//noinspection ALL
+		fmt.Fprintln(cmd.OutOrStdout(), "Configuration is valid")
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Prints the effective configuration and where each value comes from",
+	Args:  cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := config.Read(cmd); err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), "error:", err.Error())
+			os.Exit(1)
+		}
+
+		if file := viper.ConfigFileUsed(); file != "" {
+			//noinspection ALL
+			fmt.Fprintln(cmd.OutOrStdout(), "# config file:", file)
+		}
+
+		sources, err := config.Sources(cmd)
+		if err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), "error:", err.Error())
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		for _, key := range config.Keys() {
+			//noinspection ALL
+			fmt.Fprintf(w, "%s\t%v\t# %s\n", key, viper.Get(key), sources[key])
+		}
+		//noinspection ALL
+		w.Flush()
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configShowCmd)
+}
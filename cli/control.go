@@ -12,15 +12,15 @@ import (
 )
 
 var controlCmd = &cobra.Command{
-	Use:   "ctl [debug|rebuild|stop]",
-	Short: "Allows to set runner mode",
+	Use:   "ctl [debug|rebuild|build|restart|pause|resume|status|stop|profile <name>]",
+	Short: "Allows to set runner mode and trigger actions",
 
 	Run: func(cmd *cobra.Command, args []string) {
 		configuration, err := config.LoadConfig(cmd)
 		if err != nil {
 			log.Fatal("Failed to load config: " + err.Error())
 		}
-		if len(args) != 1 {
+		if len(args) != 1 && !(len(args) == 2 && args[0] == "profile") {
 			_, _ = fmt.Fprintln(cmd.OutOrStderr(), "Invalid number of arguments")
 			os.Exit(1)
 		}
@@ -49,6 +49,42 @@ var controlCmd = &cobra.Command{
 			fmt.Fprintln(cmd.OutOrStdout(), "Switching runner to live rebuild mode")
 			msg = fmt.Sprintf("%s %s", rpc.SetMode, app.ModeRebuild)
 			break
+		case "build":
+			//noinspection ALL
+			fmt.Fprintln(cmd.OutOrStdout(), "Triggering rebuild")
+			msg = rpc.Build
+			break
+		case "restart":
+			//noinspection ALL
+			fmt.Fprintln(cmd.OutOrStdout(), "Restarting application")
+			msg = rpc.Restart
+			break
+		case "pause":
+			//noinspection ALL
+			fmt.Fprintln(cmd.OutOrStdout(), "Pausing runner")
+			msg = rpc.Pause
+			break
+		case "resume":
+			action := rpc.ResumeApply
+			if discard, _ := cmd.Flags().GetBool("discard"); discard {
+				action = rpc.ResumeDrop
+			}
+			//noinspection ALL
+			fmt.Fprintln(cmd.OutOrStdout(), "Resuming runner")
+			msg = fmt.Sprintf("%s %s", rpc.Resume, action)
+			break
+		case "status":
+			msg = rpc.Status
+			break
+		case "profile":
+			if len(args) != 2 {
+				_, _ = fmt.Fprintln(cmd.OutOrStderr(), "Profile name is required, use \"none\" for no profile")
+				os.Exit(1)
+			}
+			//noinspection ALL
+			fmt.Fprintln(cmd.OutOrStdout(), "Switching profile to", args[1])
+			msg = fmt.Sprintf("%s %s", rpc.Profile, args[1])
+			break
 		case "stop":
 			//noinspection ALL
 			fmt.Fprintln(cmd.OutOrStdout(), "Stopping runner")
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kolah/runner/internal/app"
+	"github.com/kolah/runner/internal/app/config"
+	"github.com/kolah/runner/internal/app/ideconfig"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var debugConfigCmd = &cobra.Command{
+	Use:   "debug-config [vscode|goland]",
+	Short: "Generates IDE configuration connecting to the debugger",
+	Args:  cobra.MaximumNArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		configuration, err := config.LoadConfig(cmd)
+		if err != nil {
+			log.Fatal("Failed to load config: " + err.Error())
+		}
+
+		ide := "vscode"
+		if len(args) == 1 {
+			ide = args[0]
+		}
+
+		options, err := ideOptions(cmd, configuration)
+		if err != nil {
+			log.Fatal("Invalid configuration: ", err.Error())
+		}
+
+		var contents []byte
+		var target string
+		switch ide {
+		case "vscode":
+			contents, err = ideconfig.VSCode(options)
+			target = filepath.Join(".vscode", "launch.json")
+		case "goland":
+			contents, err = ideconfig.GoLand(options)
+			target = filepath.Join(".run", "runner-debug.run.xml")
+		default:
+			err = errors.New("unknown IDE " + ide)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), err.Error())
+			os.Exit(1)
+		}
+
+		if write, _ := cmd.Flags().GetBool("write"); !write {
+			//noinspection ALL
+			fmt.Fprintln(cmd.OutOrStdout(), string(contents))
+			return
+		}
+
+		if err := writeNewFile(target, contents); err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), "Failed to write configuration:", err.Error())
+			os.Exit(1)
+		}
+		//noinspection ALL
+		fmt.Fprintln(cmd.OutOrStdout(), "Configuration written to", target)
+	},
+}
+
+func init() {
+	debugConfigCmd.Flags().String("host", "localhost", "host the IDE connects to")
+	debugConfigCmd.Flags().String("remote-root", "", "project root where runner runs, e.g. inside a container")
+	debugConfigCmd.Flags().String("local-root", ideconfig.WorkspaceFolder, "project root on the IDE side")
+	debugConfigCmd.Flags().Bool("write", false, "write the configuration into the project instead of printing it")
+}
+
+func ideOptions(cmd *cobra.Command, configuration *config.Config) (ideconfig.Options, error) {
+	api, err := app.ParseDebugAPI(configuration.Run.DebugAPI)
+	if err != nil {
+		return ideconfig.Options{}, err
+	}
+
+	strategy, err := app.ParseDebugStrategy(configuration.Run.DebugStrategy)
+	if err != nil {
+		return ideconfig.Options{}, err
+	}
+
+	// the program started by the shell is not known, the first word of the command is the best guess
+	parts, err := configuration.Run.Command.WithShell(nil).Argv(config.CommandVars(configuration))
+	if err != nil {
+		return ideconfig.Options{}, fmt.Errorf("run.command: %s", err.Error())
+	}
+
+	host, _ := cmd.Flags().GetString("host")
+	remoteRoot, _ := cmd.Flags().GetString("remote-root")
+	localRoot, _ := cmd.Flags().GetString("local-root")
+
+	return ideconfig.Options{
+		Name:       "runner debug",
+		API:        api,
+		Strategy:   strategy,
+		Host:       host,
+		Port:       configuration.Run.DebugPort,
+		Program:    parts[0],
+		LocalRoot:  localRoot,
+		RemoteRoot: remoteRoot,
+	}, nil
+}
+
+// writeNewFile writes the file, refusing to overwrite an existing one
+func writeNewFile(path string, contents []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists, print the configuration and merge it manually", path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(contents, '\n'), 0644)
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/kolah/runner/internal/app"
+	"github.com/kolah/runner/internal/app/scaffold"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// configFile is the configuration runner looks for in the current directory
+const configFile = "runner.yaml"
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Detects the project stack and writes runner.yaml",
+	Args:  cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := os.Stat(configFile); err == nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), configFile, "already exists")
+			os.Exit(1)
+		}
+
+		project, err := scaffold.Detect(".")
+		if err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), "Failed to inspect the project:", err.Error())
+			os.Exit(1)
+		}
+
+		yes, _ := cmd.Flags().GetBool("yes")
+		main, err := chooseMain(cmd.InOrStdin(), cmd.OutOrStdout(), project.Mains, yes)
+		if err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), err.Error())
+			os.Exit(1)
+		}
+
+		contents, err := scaffold.Render(project, scaffold.NewConfig(project, main, app.DefaultIgnoredFiles))
+		if err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), "Failed to generate configuration:", err.Error())
+			os.Exit(1)
+		}
+
+		if err := writeNewFile(configFile, contents); err != nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStderr(), "Failed to write configuration:", err.Error())
+			os.Exit(1)
+		}
+		//noinspection ALL
+		fmt.Fprintf(cmd.OutOrStdout(), "Configuration for %s written to %s\n", main, configFile)
+	},
+}
+
+func init() {
+	initCmd.Flags().BoolP("yes", "y", false, "don't ask, use the first main package when there are several")
+}
+
+// chooseMain asks which main package to build when there are several, without asking the first one is used
+func chooseMain(in io.Reader, out io.Writer, mains []string, yes bool) (string, error) {
+	switch {
+	case len(mains) == 0:
+		if yes {
+			return ".", nil
+		}
+		return "", errors.New("no main package found in the current directory or under cmd/")
+	case len(mains) == 1 || yes:
+		return mains[0], nil
+	}
+
+	//noinspection ALL
+	fmt.Fprintln(out, "Several main packages found:")
+	for i, main := range mains {
+		//noinspection ALL
+		fmt.Fprintf(out, "  [%d] %s\n", i+1, main)
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		//noinspection ALL
+		fmt.Fprintf(out, "Which one should runner build? [1-%d, default 1]: ", len(mains))
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			return mains[0], nil
+		}
+
+		if choice, convErr := strconv.Atoi(line); convErr == nil && choice >= 1 && choice <= len(mains) {
+			return mains[choice-1], nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid choice %q", line)
+		}
+	}
+}
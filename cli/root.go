@@ -1,17 +1,28 @@
 package cli
 
 import (
+	"fmt"
 	"github.com/kolah/runner/internal/app"
 	"github.com/kolah/runner/internal/app/config"
 	"github.com/kolah/runner/internal/app/rpc"
 	"github.com/kolah/runner/internal/pkg/simplerpc"
+	"github.com/kolah/runner/internal/pkg/term"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"reflect"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
 )
 
+// shutdownTimeout limits how long the STOP command waits for runner to shut down
+const shutdownTimeout = 10 * time.Second
+
 var rootCmd = cobra.Command{
 	Use:   "runner",
 	Short: "Live reload tools",
@@ -20,7 +31,14 @@ var rootCmd = cobra.Command{
 
 func RootCommand() *cobra.Command {
 	rootCmd.PersistentFlags().StringP("config", "c", "", "the config file to use")
+	rootCmd.PersistentFlags().String("profile", "", "the profile applied over the configuration")
+	rootCmd.Flags().BoolP("interactive", "i", false, "enable key commands in the terminal")
+	controlCmd.Flags().Bool("discard", false, "drop changes collected while paused instead of applying them on resume")
 	rootCmd.AddCommand(controlCmd)
+	rootCmd.AddCommand(debugConfigCmd)
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(configCmd)
 
 	return &rootCmd
 }
@@ -38,18 +56,38 @@ func run(cmd *cobra.Command, args []string) {
 		log.Fatal("Failed to configure logger: ", err.Error())
 	}
 
+	if configuration.Profile != "" {
+		logger.Infof("Using profile %s\n", configuration.Profile)
+	}
+
 	// colored output for running application
 	appLogger := app.NewAppLog(logger)
 
-	builder := app.NewBuilder(configuration.Build.Command, configuration.Build.ErrorLog, logger)
-	watch := app.NewWatcher(configuration.Watch.Directories, configuration.Watch.IgnoredDirectories, configuration.Watch.WatchPatterns, logger)
+	builder := app.NewBuilder(configuration.Build.Command, configuration.Build.Dir, configuration.Build.ErrorLog, config.CommandVars(configuration), logger)
 
-	runnerOptions := app.NewRunnerOptions(configuration.Build.Delay, configuration.Run.Command, configuration.Run.DebugCommand, configuration.Run.BuildBeforeDebug)
-	runner := app.NewRunner(watch, builder, runnerOptions, logger, appLogger)
+	watchOptions, err := watcherOptions(configuration)
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err.Error())
+	}
+	watch := app.NewWatcher(configuration.Watch.Directories, configuration.Watch.IgnoredDirectories, configuration.Watch.WatchPatterns, configuration.Watch.IgnoredFiles, watchOptions, logger)
+
+	options, err := runnerOptions(configuration)
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err.Error())
+	}
+	runner := app.NewRunner(watch, builder, options, logger, appLogger)
+
+	shutdown := app.NewShutdown()
+
+	// reloads replace the configuration of liveConfig, run keeps reading the one it started with
+	current := *configuration
+	live := &liveConfig{current: &current, watch: watch, builder: builder, runner: runner, logger: logger}
+	commands := rpc.Commands(runner, shutdown, shutdownTimeout, live)
 
 	server := simplerpc.NewServer(configuration.CtlPort)
-	server.AddHandler(rpc.Stop, rpc.StopHandler())
-	server.AddHandler(rpc.SetMode, rpc.SetModeHandler(runner))
+	for command, handler := range commands {
+		server.AddHandler(command, rpc.TCPHandler(handler))
+	}
 
 	logger.Infof("Starting TCP server for commands on port %d\n", configuration.CtlPort)
 	if err := server.Start(); err != nil {
@@ -57,21 +95,233 @@ func run(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	var httpServer *rpc.HTTPServer
+	if configuration.HTTPPort != 0 {
+		httpServer = rpc.NewHTTPServer(configuration.HTTPHost, configuration.HTTPPort, commands, runner.Events())
+
+		logger.Infof("Starting HTTP server for commands on %s\n", net.JoinHostPort(configuration.HTTPHost, strconv.Itoa(configuration.HTTPPort)))
+		if err := httpServer.Start(); err != nil {
+			logger.Infof("Failed to start HTTP server %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	if err := runner.Start(); err != nil {
 		logger.Infof("Fatal error while starting runner %s\n", err.Error())
 		os.Exit(1)
 	}
 
+	if config.WatchConfig(live.reload) {
+		logger.Debugf("Watching config file %s for changes\n", viper.ConfigFileUsed())
+	}
+
+	if stdin, _ := app.ParseStdinMode(configuration.Run.Stdin); configuration.Interactive && stdin == app.StdinInherit {
+		logger.Info("Stdin is forwarded to the application, key commands disabled\n")
+	} else if configuration.Interactive {
+		restoreTerminal := startKeyboard(runner, shutdown, logger)
+		defer restoreTerminal()
+	}
+
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
 
-	sig := <-sigc
-
-	logger.Debugf("Caught signal %s: shutting down\n", sig)
+	select {
+	case sig := <-sigc:
+		logger.Debugf("Caught signal %s: shutting down\n", sig)
+	case <-shutdown.Requested():
+		logger.Debug("Shutdown requested: shutting down\n")
+	}
 
-	if err := runner.Stop(); err != nil {
+	err = runner.Stop()
+	if err != nil {
 		logger.Debugf("Failed to stop runner: %s\n", err.Error())
 	}
+	shutdown.Finish(err)
+
+	if httpServer != nil {
+		//noinspection ALL
+		httpServer.Stop()
+	}
 	//noinspection ALL
 	server.Stop()
 }
+
+// watcherOptions creates watcher options from the configuration
+func watcherOptions(configuration *config.Config) (app.WatcherOpts, error) {
+	backend, err := app.ParseWatchBackend(configuration.Watch.Backend)
+	if err != nil {
+		return app.WatcherOpts{}, fmt.Errorf("watch.backend: %s", err.Error())
+	}
+
+	return app.NewWatcherOptions(
+		backend,
+		configuration.Watch.PollInterval,
+		configuration.Watch.PollOnLimit,
+		configuration.Watch.RemoteListen,
+		configuration.Watch.RemoteAcceptContents,
+		configuration.Watch.FollowSymlinks,
+	), nil
+}
+
+// runnerOptions creates runner options from the configuration
+func runnerOptions(configuration *config.Config) (app.RunnerOpts, error) {
+	stdin, err := app.ParseStdinMode(configuration.Run.Stdin)
+	if err != nil {
+		return app.RunnerOpts{}, fmt.Errorf("run.stdin: %s", err.Error())
+	}
+
+	debugStrategy, err := app.ParseDebugStrategy(configuration.Run.DebugStrategy)
+	if err != nil {
+		return app.RunnerOpts{}, fmt.Errorf("run.debug_strategy: %s", err.Error())
+	}
+
+	debugOnChange, err := app.ParseDebugOnChange(configuration.Run.DebugOnChange)
+	if err != nil {
+		return app.RunnerOpts{}, fmt.Errorf("run.debug_on_change: %s", err.Error())
+	}
+
+	debugOptions := app.NewDebugOptions(
+		configuration.Run.DebugCommand,
+		configuration.Run.BuildBeforeDebug,
+		debugStrategy,
+		configuration.Run.DebugAttach,
+		debugOnChange,
+		configuration.Run.DebugIdleTimeout,
+	)
+
+	return app.NewRunnerOptions(
+		configuration.Build.Delay,
+		configuration.Build.MaxWait,
+		configuration.Run.Command,
+		configuration.Run.Dir,
+		debugOptions,
+		stdin,
+		configuration.Run.StdinFile,
+		config.CommandVars(configuration),
+	), nil
+}
+
+// liveConfig applies configuration changes to the running instance
+type liveConfig struct {
+	sync.Mutex
+	current *config.Config
+	watch   *app.Watcher
+	builder *app.Builder
+	runner  *app.Runner
+	logger  app.Logger
+}
+
+// reload applies changes of the config file, a config that can't be loaded is rejected and the previous one is kept
+func (c *liveConfig) reload(next *config.Config, err error) {
+	if err != nil {
+		c.logger.Infof("Config file changed but can't be loaded, keeping previous config: %s\n", err.Error())
+		return
+	}
+
+	if err := c.apply(next, false); err != nil {
+		c.logger.Infof("Config file changed but is invalid, keeping previous config: %s\n", err.Error())
+	}
+}
+
+// Names returns names of profiles defined in the config file, followed by the name switching back to no profile.
+func (c *liveConfig) Names() []string {
+	return append(config.Profiles(), config.NoProfile)
+}
+
+// Switch applies the profile, the application is rebuilt when build settings have changed.
+// The previous profile is selected again when the configuration can't be applied.
+func (c *liveConfig) Switch(name string) error {
+	previous := config.ActiveProfile()
+	next, err := config.SwitchProfile(name)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Switching profile to %s\n", name)
+
+	c.Lock()
+	rebuild := !reflect.DeepEqual(c.current.Build, next.Build)
+	c.Unlock()
+
+	if err := c.apply(next, rebuild); err != nil {
+		// a failed build leaves the profile applied, the application is built again on the next change
+		c.Lock()
+		applied := reflect.DeepEqual(c.current, next)
+		c.Unlock()
+		if applied {
+			return err
+		}
+
+		if _, restoreErr := config.SwitchProfile(previous); restoreErr != nil {
+			c.logger.Infof("Unable to select profile %s again: %s\n", previous, restoreErr.Error())
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (c *liveConfig) apply(next *config.Config, rebuild bool) error {
+	c.Lock()
+	defer c.Unlock()
+
+	watchOptions, err := watcherOptions(next)
+	if err != nil {
+		return err
+	}
+
+	options, err := runnerOptions(next)
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(c.current, next) {
+		return nil
+	}
+	c.logger.Info("Configuration changed, applying\n")
+
+	if !reflect.DeepEqual(c.current.Watch, next.Watch) {
+		c.logger.Info("Watch settings changed, registering watches again\n")
+		err := c.watch.Reconfigure(next.Watch.Directories, next.Watch.IgnoredDirectories, next.Watch.WatchPatterns, next.Watch.IgnoredFiles, watchOptions)
+		if err != nil {
+			previousOptions, _ := watcherOptions(c.current)
+			if err := c.watch.Reconfigure(c.current.Watch.Directories, c.current.Watch.IgnoredDirectories, c.current.Watch.WatchPatterns, c.current.Watch.IgnoredFiles, previousOptions); err != nil {
+				c.logger.Infof("Failed to restore watch settings: %s\n", err.Error())
+			}
+			return err
+		}
+	}
+
+	_ = os.MkdirAll(next.Build.TmpDir, 0755)
+	c.builder.SetCommand(next.Build.Command, next.Build.Dir, next.Build.ErrorLog, config.CommandVars(next))
+
+	if c.current.CtlPort != next.CtlPort || c.current.HTTPPort != next.HTTPPort || c.current.HTTPHost != next.HTTPHost ||
+		c.current.Interactive != next.Interactive || c.current.Logging != next.Logging {
+		c.logger.Info("Changes of ctl_port, http_port, http_host, interactive and logging take effect when runner is restarted\n")
+	}
+
+	*c.current = *next
+
+	return c.runner.Reconfigure(options, rebuild)
+}
+
+// startKeyboard enables key commands when stdin is a terminal, the returned function restores the terminal
+func startKeyboard(runner *app.Runner, shutdown *app.Shutdown, logger app.Logger) func() {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		logger.Info("Stdin is not a terminal, key commands disabled\n")
+		return func() {}
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		logger.Infof("Failed to configure terminal, key commands disabled: %s\n", err.Error())
+		return func() {}
+	}
+
+	app.NewKeyboard(os.Stdin, runner, shutdown, logger).Start()
+
+	return func() {
+		_ = term.Restore(fd, state)
+	}
+}
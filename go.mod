@@ -6,7 +6,9 @@ require (
 	github.com/fsnotify/fsnotify v1.4.7
 	github.com/gookit/color v1.1.7
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/shirou/gopsutil v2.18.12+incompatible
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/viper v1.4.0
+	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a
 )
@@ -1,12 +1,13 @@
 package app
 
 import (
-	"github.com/kballard/go-shellquote"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 type BuildErr struct {
@@ -22,27 +23,44 @@ func (e BuildErr) Error() string {
 }
 
 type Builder struct {
-	buildCommand string
+	sync.Mutex
+	buildCommand Command
+	dir          string
 	errorLogPath string
-	logger       Logger
+	vars         CommandVars
+	// lastError is read without the lock held by builds in progress
+	lastError atomic.Value
+	logger    Logger
 }
 
-func NewBuilder(buildCommand, errorLogPath string, logger Logger) *Builder {
+// NewBuilder creates a builder running the build command in dir, current directory when empty.
+func NewBuilder(buildCommand Command, dir, errorLogPath string, vars CommandVars, logger Logger) *Builder {
 	return &Builder{
 		buildCommand: buildCommand,
+		dir:          dir,
 		errorLogPath: errorLogPath,
+		vars:         vars,
 		logger:       logger,
 	}
 }
 
-func (b *Builder) Build() error {
+// ChangedFilesEnv is the environment variable holding files that triggered the build, separated by newlines.
+const ChangedFilesEnv = "RUNNER_CHANGED_FILES"
+
+// Build runs the build command for the mode, concurrent builds are serialized.
+func (b *Builder) Build(changedFiles []string, mode RunnerMode) error {
+	b.Lock()
+	defer b.Unlock()
+
 	b.removeBuildErrorsLog()
+	b.lastError.Store("")
 
 	b.logger.Info("Building...\n")
 
-	parts, err := shellquote.Split(b.buildCommand)
-
+	parts, err := b.buildCommand.Argv(b.vars.with(mode, changedFiles))
 	if err != nil {
+		b.logger.Infof("Unable to execute build %s\n", err.Error())
+
 		return err
 	}
 
@@ -50,10 +68,12 @@ func (b *Builder) Build() error {
 	parts = parts[1:]
 
 	cmd := exec.Command(head, parts...)
+	cmd.Dir = b.dir
+	cmd.Env = append(os.Environ(), ChangedFilesEnv+"="+strings.Join(changedFiles, "\n"))
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	stdout, err := cmd.StdoutPipe()
@@ -67,13 +87,20 @@ func (b *Builder) Build() error {
 
 		return err
 	}
-	//noinspection ALL
-	io.Copy(os.Stdout, stdout)
+	// both pipes are read at once, the build blocks when the buffer of the one not being read fills up
+	copied := make(chan bool)
+	go func() {
+		//noinspection ALL
+		io.Copy(os.Stdout, stdout)
+		close(copied)
+	}()
 	errBuf, _ := ioutil.ReadAll(stderr)
+	<-copied
 
 	err = cmd.Wait()
 	if err != nil {
 		errorMessage := string(errBuf)
+		b.lastError.Store(errorMessage)
 		b.createBuildErrorsLog(errorMessage)
 		b.logger.Infof("Build failed %s\n", err.Error())
 
@@ -85,6 +112,26 @@ func (b *Builder) Build() error {
 	return nil
 }
 
+// SetCommand replaces the build command, its directory, the error log location and the values available
+// to the command, builds in progress are not affected.
+func (b *Builder) SetCommand(buildCommand Command, dir, errorLogPath string, vars CommandVars) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.buildCommand = buildCommand
+	b.dir = dir
+	b.errorLogPath = errorLogPath
+	b.vars = vars
+}
+
+// LastError returns the output of the last failed build, it's empty when the last build succeeded.
+// It doesn't wait for the build in progress.
+func (b *Builder) LastError() string {
+	lastError, _ := b.lastError.Load().(string)
+
+	return lastError
+}
+
 func (b *Builder) createBuildErrorsLog(message string) {
 	file, err := os.Create(b.errorLogPath)
 	if err != nil {
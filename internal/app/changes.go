@@ -0,0 +1,103 @@
+package app
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// listedChanges is the number of files named when changes are logged
+const listedChanges = 2
+
+// FileChange is a changed file along with the operations performed on it.
+type FileChange struct {
+	File string `json:"file"`
+	Ops  string `json:"ops"`
+}
+
+// ChangeSet collects changed files without duplicates, in order of the first change.
+// It's not safe for concurrent use.
+type ChangeSet struct {
+	files []string
+	ops   map[string]fsnotify.Op
+}
+
+func NewChangeSet() *ChangeSet {
+	return &ChangeSet{ops: make(map[string]fsnotify.Op)}
+}
+
+// Add records the file and operation of the event.
+func (c *ChangeSet) Add(event fsnotify.Event) {
+	name := filepath.Clean(event.Name)
+	if _, ok := c.ops[name]; !ok {
+		c.files = append(c.files, name)
+	}
+	c.ops[name] |= event.Op
+}
+
+// Merge adds changes of the other set.
+func (c *ChangeSet) Merge(other *ChangeSet) {
+	for _, name := range other.files {
+		c.Add(fsnotify.Event{Name: name, Op: other.ops[name]})
+	}
+}
+
+// settle resolves atomic saves once files stopped changing. A file replaced by renaming a temporary
+// file over it is reported as written, files created and removed again, like the temporary file, are dropped.
+func (c *ChangeSet) settle() *ChangeSet {
+	settled := NewChangeSet()
+	for _, name := range c.files {
+		op := c.ops[name]
+		_, err := os.Lstat(name)
+		exists := err == nil
+
+		switch {
+		case !exists && op&fsnotify.Create != 0:
+			continue
+		case exists && op&(fsnotify.Remove|fsnotify.Rename) != 0:
+			op = fsnotify.Write
+		}
+
+		settled.Add(fsnotify.Event{Name: name, Op: op})
+	}
+
+	return settled
+}
+
+func (c *ChangeSet) Len() int {
+	return len(c.files)
+}
+
+// Files returns names of changed files.
+func (c *ChangeSet) Files() []string {
+	return append([]string(nil), c.files...)
+}
+
+// Changes returns changed files with their operations.
+func (c *ChangeSet) Changes() []FileChange {
+	changes := make([]FileChange, 0, len(c.files))
+	for _, name := range c.files {
+		changes = append(changes, FileChange{File: name, Ops: c.ops[name].String()})
+	}
+
+	return changes
+}
+
+// String describes the changes like "3 files changed: a.go, b.go (+1)".
+func (c *ChangeSet) String() string {
+	noun := "files"
+	if len(c.files) == 1 {
+		noun = "file"
+	}
+
+	if len(c.files) <= listedChanges {
+		return fmt.Sprintf("%d %s changed: %s", len(c.files), noun, strings.Join(c.files, ", "))
+	}
+
+	return fmt.Sprintf(
+		"%d %s changed: %s (+%d)",
+		len(c.files), noun, strings.Join(c.files[:listedChanges], ", "), len(c.files)-listedChanges,
+	)
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"errors"
+	"github.com/kballard/go-shellquote"
+	"os"
+	"strings"
+)
+
+// ErrEmptyCommand is returned when a command has no program to run.
+var ErrEmptyCommand = errors.New("command is empty")
+
+// Command is a command line split into arguments like a shell would, without running a shell,
+// a list of arguments used as is, or a command line passed to a shell interpreter.
+type Command struct {
+	line  string
+	args  []string
+	shell []string
+}
+
+// NewCommand creates a command from a command line.
+func NewCommand(line string) Command {
+	return Command{line: line}
+}
+
+// NewArgsCommand creates a command from the program and its arguments, they are not parsed.
+func NewArgsCommand(args ...string) Command {
+	return Command{args: args}
+}
+
+// WithShell returns the command run by the interpreter, e.g. sh -c, which receives the command line as an argument.
+func (c Command) WithShell(interpreter []string) Command {
+	c.shell = interpreter
+
+	return c
+}
+
+// WithArg returns the command with the argument appended.
+func (c Command) WithArg(arg string) Command {
+	if c.args != nil {
+		c.args = append(append([]string{}, c.args...), arg)
+		return c
+	}
+	c.line += " " + shellquote.Join(arg)
+
+	return c
+}
+
+// Empty tells whether the command has no program to run.
+func (c Command) Empty() bool {
+	if c.args != nil {
+		return len(c.args) == 0 || c.args[0] == ""
+	}
+
+	parts, err := shellquote.Split(c.line)
+
+	return err == nil && len(parts) == 0
+}
+
+func (c Command) String() string {
+	line := c.line
+	if c.args != nil {
+		line = shellquote.Join(c.args...)
+	}
+	if c.shell != nil {
+		return shellquote.Join(append(c.shell, line)...)
+	}
+
+	return line
+}
+
+// Argv evaluates templates of the command and returns the program followed by its arguments.
+func (c Command) Argv(vars CommandVars) ([]string, error) {
+	var args []string
+	if c.args != nil {
+		for _, arg := range c.args {
+			if changedFilesArg.MatchString(arg) {
+				args = append(args, vars.ChangedFiles...)
+				continue
+			}
+			rendered, err := RenderCommand(arg, vars)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, rendered)
+		}
+	} else {
+		// the line is split by the shell or shellquote, paths containing spaces must stay single arguments
+		line, err := RenderCommand(c.line, vars.quoted())
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case c.shell == nil:
+			if args, err = shellquote.Split(line); err != nil {
+				return nil, err
+			}
+		case strings.TrimSpace(line) != "":
+			args = []string{line}
+		}
+	}
+
+	if len(args) == 0 || args[0] == "" {
+		return nil, ErrEmptyCommand
+	}
+
+	if c.shell != nil {
+		if c.args != nil {
+			args = []string{shellquote.Join(args...)}
+		}
+		args = append(append([]string{}, c.shell...), args...)
+	}
+
+	return args, nil
+}
+
+// ParseShell returns the interpreter running shell commands with its arguments, $SHELL -c when empty.
+func ParseShell(interpreter string) ([]string, error) {
+	if interpreter == "" {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+
+		return []string{shell, "-c"}, nil
+	}
+
+	args, err := shellquote.Split(interpreter)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("shell interpreter is empty")
+	}
+
+	return args, nil
+}
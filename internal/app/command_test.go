@@ -0,0 +1,136 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArgv(t *testing.T) {
+	vars := NewCommandVars("tmp", "tmp/build", "/project", 2345)
+	shell := []string{"sh", "-c"}
+
+	tests := []struct {
+		name     string
+		command  Command
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "line is split like a shell would",
+			command:  NewCommand(`go build -ldflags "-X main.version=1.0" -o tmp/build .`),
+			expected: []string{"go", "build", "-ldflags", "-X main.version=1.0", "-o", "tmp/build", "."},
+		},
+		{
+			name:     "shell line is passed to the interpreter as is",
+			command:  NewCommand("go generate ./... && go build .").WithShell(shell),
+			expected: []string{"sh", "-c", "go generate ./... && go build ."},
+		},
+		{
+			name:     "list is used as is",
+			command:  NewArgsCommand("app", "--name", "value with spaces", "$HOME"),
+			expected: []string{"app", "--name", "value with spaces", "$HOME"},
+		},
+		{
+			name:     "list is quoted for the interpreter",
+			command:  NewArgsCommand("app", "--name", "value with spaces").WithShell(shell),
+			expected: []string{"sh", "-c", "app --name 'value with spaces'"},
+		},
+		{
+			name:     "argument appended to a line is quoted",
+			command:  NewCommand("dlv attach").WithArg("12 34"),
+			expected: []string{"dlv", "attach", "12 34"},
+		},
+		{
+			name:     "argument appended to a list",
+			command:  NewArgsCommand("dlv", "attach").WithArg("1234"),
+			expected: []string{"dlv", "attach", "1234"},
+		},
+		{
+			name:    "empty line",
+			command: NewCommand("  "),
+			err:     true,
+		},
+		{
+			name:    "empty shell line",
+			command: NewCommand("").WithShell(shell),
+			err:     true,
+		},
+		{
+			name:    "empty list",
+			command: NewArgsCommand(),
+			err:     true,
+		},
+		{
+			name:    "unterminated quote",
+			command: NewCommand(`go build -o "tmp/build`),
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		args, err := test.command.Argv(vars)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", test.name, args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, args)
+		}
+	}
+}
+
+func TestCommandEmpty(t *testing.T) {
+	tests := []struct {
+		command Command
+		empty   bool
+	}{
+		{NewCommand(""), true},
+		{NewCommand("  "), true},
+		{NewCommand("go build"), false},
+		{NewArgsCommand(), true},
+		{NewArgsCommand(""), true},
+		{NewArgsCommand("go"), false},
+	}
+
+	for _, test := range tests {
+		if empty := test.command.Empty(); empty != test.empty {
+			t.Errorf("%q: expected empty %v, got %v", test.command.String(), test.empty, empty)
+		}
+	}
+}
+
+func TestParseShell(t *testing.T) {
+	tests := []struct {
+		interpreter string
+		expected    []string
+		err         bool
+	}{
+		{interpreter: "bash -euc", expected: []string{"bash", "-euc"}},
+		{interpreter: "'/opt/my shell/sh' -c", expected: []string{"/opt/my shell/sh", "-c"}},
+		{interpreter: "  ", err: true},
+		{interpreter: "sh '-c", err: true},
+	}
+
+	for _, test := range tests {
+		args, err := ParseShell(test.interpreter)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.interpreter)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.interpreter, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.interpreter, test.expected, args)
+		}
+	}
+}
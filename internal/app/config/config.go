@@ -1,10 +1,16 @@
 package config
 
 import (
+	"fmt"
 	"github.com/kolah/runner/internal/app"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,81 +19,381 @@ type Logging struct {
 }
 
 type Watch struct {
-	Directories        []string
-	WatchPatterns      []string `mapstructure:"watch_patterns" yaml:"watch_patterns"`
-	IgnoredDirectories []string `mapstructure:"ignored_directories" yaml:"ignored_directories"`
+	Directories          []string
+	WatchPatterns        []string      `mapstructure:"watch_patterns" yaml:"watch_patterns"`
+	IgnoredDirectories   []string      `mapstructure:"ignored_directories" yaml:"ignored_directories"`
+	IgnoredFiles         []string      `mapstructure:"ignored_files" yaml:"ignored_files"`
+	Backend              string        `mapstructure:"backend" yaml:"backend"`
+	PollInterval         time.Duration `mapstructure:"poll_interval" yaml:"poll_interval"`
+	PollOnLimit          bool          `mapstructure:"poll_on_limit" yaml:"poll_on_limit"`
+	RemoteListen         string        `mapstructure:"remote_listen" yaml:"remote_listen"`
+	RemoteAcceptContents bool          `mapstructure:"remote_accept_contents" yaml:"remote_accept_contents"`
+	FollowSymlinks       bool          `mapstructure:"follow_symlinks" yaml:"follow_symlinks"`
 }
 
 type Build struct {
-	Delay    time.Duration
-	Command  string
-	ErrorLog string `mapstructure:"error_log" yaml:"error_log"`
-	TmpDir   string `mapstructure:"tmp_dir" yaml:"tmp_dir"`
+	Delay      time.Duration
+	MaxWait    time.Duration `mapstructure:"max_wait" yaml:"max_wait"`
+	Command    app.Command
+	Shell      bool
+	Dir        string
+	BinaryPath string `mapstructure:"binary_path" yaml:"binary_path"`
+	ErrorLog   string `mapstructure:"error_log" yaml:"error_log"`
+	TmpDir     string `mapstructure:"tmp_dir" yaml:"tmp_dir"`
 }
 
 type Run struct {
-	Command          string
-	DebugCommand     string `mapstructure:"debug_command" yaml:"debug_command"`
-	BuildBeforeDebug bool   `mapstructure:"build_before_debug" yaml:"build_before_debug"`
+	Command          app.Command
+	Shell            bool
+	Dir              string
+	DebugCommand     app.Command   `mapstructure:"debug_command" yaml:"debug_command"`
+	DebugAPI         string        `mapstructure:"debug_api" yaml:"debug_api"`
+	DebugPort        int           `mapstructure:"debug_port" yaml:"debug_port"`
+	BuildBeforeDebug bool          `mapstructure:"build_before_debug" yaml:"build_before_debug"`
+	DebugStrategy    string        `mapstructure:"debug_strategy" yaml:"debug_strategy"`
+	DebugAttach      app.Command   `mapstructure:"debug_attach_command" yaml:"debug_attach_command"`
+	DebugOnChange    string        `mapstructure:"debug_on_change" yaml:"debug_on_change"`
+	DebugIdleTimeout time.Duration `mapstructure:"debug_idle_timeout" yaml:"debug_idle_timeout"`
+	Stdin            string
+	StdinFile        string `mapstructure:"stdin_file" yaml:"stdin_file"`
 }
 
 type Config struct {
-	Watch   Watch
-	Run     Run
-	Build   Build
-	Logging Logging
-	CtlPort int `mapstructure:"ctl_port" yaml:"ctl_port"`
+	Watch       Watch
+	Run         Run
+	Build       Build
+	Logging     Logging
+	CtlPort     int    `mapstructure:"ctl_port" yaml:"ctl_port"`
+	HTTPPort    int    `mapstructure:"http_port" yaml:"http_port"`
+	HTTPHost    string `mapstructure:"http_host" yaml:"http_host"`
+	Interactive bool
+	// ShellInterpreter runs commands with shell enabled, $SHELL -c when empty
+	ShellInterpreter string `mapstructure:"shell_interpreter" yaml:"shell_interpreter"`
+	// Profile is the name of the profile applied over the configuration
+	Profile string
 }
 
 func LoadConfig(cmd *cobra.Command) (*Config, error) {
+	if err := Read(cmd); err != nil {
+		return nil, err
+	}
+
+	return unmarshal()
+}
+
+// Read sets up defaults, flags and environment variables and reads the config file, without decoding it.
+func Read(cmd *cobra.Command) error {
 	err := viper.BindPFlags(cmd.Flags())
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.SetEnvPrefix("RUNNER")
 	viper.AutomaticEnv()
 
-	viper.SetDefault("ctl_port", 55555)
+	setDefault("ctl_port", 55555)
+	setDefault("http_port", 0)
+	setDefault("http_host", "127.0.0.1")
+	setDefault("interactive", false)
+	setDefault("profile", "")
+	setDefault("shell_interpreter", "")
 
-	viper.SetDefault("watch.directories", []string{"."})
-	viper.SetDefault("watch.watch_patterns", []string{"*.go"})
-	viper.SetDefault("watch.ignore_directories", []string{"tmp", "vendor"})
+	setDefault("watch.directories", []string{"."})
+	setDefault("watch.watch_patterns", []string{"*.go"})
+	setDefault("watch.ignored_directories", []string{"tmp", "vendor"})
+	setDefault("watch.ignored_files", app.DefaultIgnoredFiles)
+	setDefault("watch.backend", "fsnotify")
+	setDefault("watch.poll_interval", 500*time.Millisecond)
+	setDefault("watch.poll_on_limit", true)
+	setDefault("watch.remote_listen", "tcp://127.0.0.1:55556")
+	setDefault("watch.remote_accept_contents", false)
+	setDefault("watch.follow_symlinks", false)
 
-	viper.SetDefault("build.command", "go build -gcflags='all=-N=-l' -o tmp/tmp-build .")
-	viper.SetDefault("build.error_log", "tmp/build_error.log")
-	viper.SetDefault("build.delay", 650*time.Millisecond)
-	viper.SetDefault("build.tmp_dir", "tmp")
+	setDefault("build.command", "go build -gcflags='all=-N -l' -o {{.BinaryPath}} .")
+	setDefault("build.shell", false)
+	setDefault("build.dir", "")
+	setDefault("build.binary_path", "tmp/tmp-build")
+	setDefault("build.error_log", "tmp/build_error.log")
+	setDefault("build.delay", 650*time.Millisecond)
+	setDefault("build.max_wait", 5*time.Second)
+	setDefault("build.tmp_dir", "tmp")
 
-	viper.SetDefault("run.command", "tmp/tmp-build")
-	viper.SetDefault("run.debug_command", "dlv --headless --listen=:2345 --api-version=2 exec tmp/tmp-build")
-	viper.SetDefault("run.build_before_debug", true)
+	setDefault("run.command", "{{.BinaryPath}}")
+	setDefault("run.shell", false)
+	setDefault("run.dir", "")
+	setDefault("run.debug_command", "")
+	setDefault("run.debug_api", "jsonrpc")
+	setDefault("run.debug_port", 2345)
+	setDefault("run.build_before_debug", true)
+	setDefault("run.debug_strategy", "exec")
+	setDefault("run.debug_on_change", "ignore")
+	setDefault("run.debug_idle_timeout", 0)
+	setDefault("run.debug_attach_command", "")
+	setDefault("run.stdin", "none")
+	setDefault("run.stdin_file", "")
 
-	viper.SetDefault("logging.level", "info")
+	setDefault("logging.level", "info")
 
-	if configFile, _ := cmd.Flags().GetString("config"); configFile != "" {
+	configFile, _ := cmd.Flags().GetString("config")
+	if configFile != "" {
 		viper.SetConfigFile(configFile)
+		configType = strings.TrimPrefix(filepath.Ext(configFile), ".")
 	} else {
 		viper.SetConfigFile("runner.yaml")
-		viper.SetConfigType("yaml")
 		viper.AddConfigPath(".")
+		configType = "yaml"
 	}
+	viper.SetConfigType(configType)
+
+	// runner.yaml is optional, a config file given explicitly is not
+	if err := readFile(); err != nil && (configFile != "" || !os.IsNotExist(err)) {
+		return err
+	}
+
+	return applyProfile()
+}
+
+// defaults are default values of options, lists set with append in config files are appended to them
+var defaults = make(map[string]interface{})
+
+func setDefault(key string, value interface{}) {
+	defaults[key] = value
+	viper.SetDefault(key, value)
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		switch err.(type) {
-		case viper.ConfigParseError:
-			return nil, err
+// configType is the format of the config file
+var configType string
+
+// reloadLock serializes reloading of the configuration
+var reloadLock sync.Mutex
+
+// reloadDelay is how long the config file must stay unchanged before it's reloaded
+const reloadDelay = 100 * time.Millisecond
+
+// WatchConfig calls onChange with the reloaded configuration whenever the config file changes.
+// When the file can't be loaded, onChange receives the error instead.
+func WatchConfig(onChange func(config *Config, err error)) bool {
+	if _, err := os.Stat(viper.ConfigFileUsed()); err != nil {
+		return false
+	}
+
+	var lock sync.Mutex
+	var timer *time.Timer
+	reload := func() {
+		reloadLock.Lock()
+		defer reloadLock.Unlock()
+
+		// viper keeps the previous configuration when the file can't be parsed, read it again to get the error
+		if err := readConfig(); err != nil {
+			onChange(nil, err)
+			return
 		}
+
+		onChange(unmarshal())
+	}
+
+	// editors often truncate the file before writing it, wait until it's written
+	changed := func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(reloadDelay, reload)
 	}
+	// viper.WatchConfig reads the file without holding reloadLock, the files are watched by runner instead
+	return watchConfigFiles(changed) == nil
+}
+
+var commandType = reflect.TypeOf(app.Command{})
+
+// decodeCommand decodes commands given as a command line or as a list of arguments
+func decodeCommand(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != commandType {
+		return data, nil
+	}
+
+	switch value := data.(type) {
+	case string:
+		return app.NewCommand(value), nil
+	case []interface{}:
+		args := make([]string, 0, len(value))
+		for _, arg := range value {
+			args = append(args, fmt.Sprint(arg))
+		}
+		return app.NewArgsCommand(args...), nil
+	case []string:
+		return app.NewArgsCommand(value...), nil
+	}
+
+	return nil, fmt.Errorf("expected a command line or a list of arguments, got %v", data)
+}
+
+func unmarshal() (*Config, error) {
+	config, err := decode()
+	if err != nil {
+		return nil, err
+	}
+
+	if errs := validateCommands(config); len(errs) > 0 {
+		return nil, errs
+	}
+
+	if err := resolveDebugCommands(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// decode decodes the configuration and resolves directories and shell commands, commands are not evaluated
+func decode() (*Config, error) {
 	config := Config{}
-	if err := viper.Unmarshal(&config); err != nil {
+	hook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		decodeCommand,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+	if err := viper.Unmarshal(&config, hook); err != nil {
+		return nil, err
+	}
+
+	resolveDirs(&config)
+
+	if err := resolveShell(&config); err != nil {
 		return nil, err
 	}
 
 	return &config, nil
 }
 
+// CommandError is returned when a command is empty or can't be evaluated.
+type CommandError struct {
+	Key string
+	Err error
+}
+
+func (e CommandError) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
+
+// CommandErrors are returned when one or more commands are empty or can't be evaluated.
+type CommandErrors []CommandError
+
+func (e CommandErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// validateCommands evaluates commands, so that mistakes in templates are reported before the first build.
+// Debug commands which are not set are derived later and not evaluated.
+func validateCommands(config *Config) CommandErrors {
+	var errs CommandErrors
+	commands := []struct {
+		key     string
+		command app.Command
+	}{
+		{"build.command", config.Build.Command},
+		{"run.command", config.Run.Command},
+		{"run.debug_command", config.Run.DebugCommand},
+		{"run.debug_attach_command", config.Run.DebugAttach},
+	}
+	for _, c := range commands {
+		if c.key != "build.command" && c.key != "run.command" && c.command.Empty() {
+			continue
+		}
+		if _, err := c.command.Argv(CommandVars(config)); err != nil {
+			errs = append(errs, CommandError{Key: c.key, Err: err})
+		}
+	}
+
+	return errs
+}
+
+// resolveDirs makes relative working directories of commands and paths of build outputs relative to the directory
+// of the config file, so that commands running in different directories refer to the same files
+func resolveDirs(config *Config) {
+	paths := []*string{&config.Build.Dir, &config.Run.Dir, &config.Build.BinaryPath, &config.Build.TmpDir, &config.Build.ErrorLog}
+	for _, path := range paths {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(ProjectRoot(), *path)
+		}
+	}
+}
+
+// resolveShell passes commands with shell enabled to the shell interpreter, run.shell applies to the debug command
+// only when it's set explicitly
+func resolveShell(config *Config) error {
+	shell, err := app.ParseShell(config.ShellInterpreter)
+	if err != nil {
+		return fmt.Errorf("shell_interpreter: %s", err.Error())
+	}
+
+	if config.Build.Shell {
+		config.Build.Command = config.Build.Command.WithShell(shell)
+	}
+	if config.Run.Shell {
+		config.Run.Command = config.Run.Command.WithShell(shell)
+		if !config.Run.DebugCommand.Empty() {
+			config.Run.DebugCommand = config.Run.DebugCommand.WithShell(shell)
+		}
+	}
+
+	return nil
+}
+
+// CommandVars returns the values available in command templates.
+func CommandVars(config *Config) app.CommandVars {
+	return app.NewCommandVars(config.Build.TmpDir, config.Build.BinaryPath, ProjectRoot(), config.Run.DebugPort)
+}
+
+// ProjectRoot returns the directory of the config file, or the working directory when there is no config file.
+func ProjectRoot() string {
+	if file := viper.ConfigFileUsed(); file != "" {
+		if _, err := os.Stat(file); err == nil {
+			if root, err := filepath.Abs(filepath.Dir(file)); err == nil {
+				return root
+			}
+		}
+	}
+
+	root, _ := os.Getwd()
+
+	return root
+}
+
+// resolveDebugCommands derives debug commands not set explicitly from the debug API and port,
+// the derived debug command starts the run command evaluated when the configuration is loaded, without a shell
+func resolveDebugCommands(config *Config) error {
+	run := &config.Run
+	api, err := app.ParseDebugAPI(run.DebugAPI)
+	if err != nil {
+		return err
+	}
+
+	if run.DebugCommand.Empty() {
+		runArgs, err := run.Command.WithShell(nil).Argv(CommandVars(config))
+		if err != nil {
+			return CommandError{Key: "run.command", Err: err}
+		}
+		if run.DebugCommand, err = app.DefaultDebugCommand(api, run.DebugPort, runArgs); err != nil {
+			return err
+		}
+	}
+
+	if run.DebugAttach.Empty() {
+		run.DebugAttach = app.DefaultDebugAttachCommand(run.DebugPort)
+	}
+
+	return nil
+}
+
 func ConfigureLogging(config Logging) (app.Logger, error) {
 	level, err := app.ParseLevel(config.Level)
 
@@ -96,4 +402,4 @@ func ConfigureLogging(config Logging) (app.Logger, error) {
 	}
 
 	return app.NewStdoutLog(level), nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// extendsKey names the config file a config file extends, relative to the extending file
+const extendsKey = "extends"
+
+// controls of merging a list with the list of the extended file, e.g. ignored_directories: {append: [node_modules]}
+const (
+	listAppend  = "append"
+	listReplace = "replace"
+)
+
+// configFiles are the config file and the files it extends, the most specific first
+var configFiles []string
+
+// fileSettings are the settings of the config file merged over the files it extends, profiles are applied over them
+var fileSettings map[string]interface{}
+
+// ExtendsCycleError is returned when config files extend each other.
+type ExtendsCycleError struct {
+	Files []string
+}
+
+func (e ExtendsCycleError) Error() string {
+	return "config files extend each other: " + strings.Join(e.Files, " -> ")
+}
+
+// readFile reads the config file merged over the files it extends
+func readFile() error {
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+
+	settings, files, err := readSettings(viper.ConfigFileUsed(), nil)
+	if err != nil {
+		return err
+	}
+	if err := appendDefaults(settings, ""); err != nil {
+		return err
+	}
+
+	if err := setSettings(settings); err != nil {
+		return err
+	}
+
+	configFiles = files
+	fileSettings = settings
+
+	return nil
+}
+
+// setSettings replaces values read from the config file, the file can be in any format viper supports
+func setSettings(settings map[string]interface{}) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	viper.SetConfigType("json")
+	defer viper.SetConfigType(configType)
+
+	return viper.ReadConfig(bytes.NewReader(data))
+}
+
+// watchedFiles returns the config file and the files it extends
+func watchedFiles() []string {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	return configFiles
+}
+
+// watchConfigFiles calls onChange when the config file or one of the files it extends changes,
+// directories of files added to the extends chain later are watched as they appear
+func watchConfigFiles(onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	watchDirs := func() {
+		for _, file := range watchedFiles() {
+			_ = watcher.Add(filepath.Dir(file))
+		}
+	}
+	watchDirs()
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				for _, file := range watchedFiles() {
+					if filepath.Clean(event.Name) == file {
+						onChange()
+						break
+					}
+				}
+				watchDirs()
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// readSettings reads the file and the files it extends, chain holds the files extending it
+func readSettings(file string, chain []string) (map[string]interface{}, []string, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, extending := range chain {
+		if extending == file {
+			return nil, nil, ExtendsCycleError{Files: append(chain[i:], file)}
+		}
+	}
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %s", file, err)
+	}
+
+	settings := v.AllSettings()
+	base, ok := settings[extendsKey]
+	delete(settings, extendsKey)
+
+	path, isPath := base.(string)
+	if ok && !isPath {
+		return nil, nil, fmt.Errorf("%s: %s must be a path of a config file", file, extendsKey)
+	}
+	if path == "" {
+		return mergeSettings(nil, settings), []string{file}, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(file), path)
+	}
+
+	baseSettings, files, err := readSettings(path, append(chain, file))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return mergeSettings(baseSettings, settings), append([]string{file}, files...), nil
+}
+
+// mergeSettings merges settings over the base recursively, lists replace lists of the base
+// unless they are appended explicitly. Lists appended when the base has no list are kept
+// as {append: [...]} and appended to the default value by appendDefaults.
+func mergeSettings(base, settings map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(settings))
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range settings {
+		if list, action, ok := listControl(value); ok {
+			current, isList := merged[key].([]interface{})
+			pending, _, isPending := listControl(merged[key])
+			switch {
+			case action == listReplace:
+				merged[key] = list
+			case isList:
+				merged[key] = append(append([]interface{}{}, current...), list...)
+			case isPending:
+				merged[key] = map[string]interface{}{listAppend: append(append([]interface{}{}, pending...), list...)}
+			default:
+				merged[key] = map[string]interface{}{listAppend: list}
+			}
+			continue
+		}
+
+		if section, ok := value.(map[string]interface{}); ok {
+			baseSection, _ := merged[key].(map[string]interface{})
+			merged[key] = mergeSettings(baseSection, section)
+			continue
+		}
+
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// appendDefaults appends lists kept as {append: [...]} to default values of their keys, prefix is the key
+// of the settings section
+func appendDefaults(settings map[string]interface{}, prefix string) error {
+	for key, value := range settings {
+		if prefix == "" && key == profilesKey {
+			// profiles append to the configuration they are applied over
+			continue
+		}
+
+		list, action, ok := listControl(value)
+		if !ok {
+			if section, isSection := value.(map[string]interface{}); isSection {
+				if err := appendDefaults(section, prefix+key+"."); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		defaultList, isList := toList(defaults[prefix+key])
+		if action == listAppend && !isList {
+			return fmt.Errorf("%s%s: there is no list to append to", prefix, key)
+		}
+		if action == listAppend {
+			list = append(defaultList, list...)
+		}
+		settings[key] = list
+	}
+
+	return nil
+}
+
+// toList converts a list of any type into a list of values
+func toList(value interface{}) ([]interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if value == nil || (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) {
+		return nil, false
+	}
+
+	list := make([]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		list = append(list, v.Index(i).Interface())
+	}
+
+	return list, true
+}
+
+// listControl recognizes {append: [...]} and {replace: [...]} values
+func listControl(value interface{}) ([]interface{}, string, bool) {
+	control, ok := value.(map[string]interface{})
+	if !ok || len(control) != 1 {
+		return nil, "", false
+	}
+
+	for action, value := range control {
+		list, ok := value.([]interface{})
+		if !ok || (action != listAppend && action != listReplace) {
+			return nil, "", false
+		}
+
+		return list, action, true
+	}
+
+	return nil, "", false
+}
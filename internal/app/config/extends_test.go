@@ -0,0 +1,266 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func list(values ...interface{}) []interface{} {
+	return values
+}
+
+func appended(values ...interface{}) map[string]interface{} {
+	return map[string]interface{}{listAppend: list(values...)}
+}
+
+func replaced(values ...interface{}) map[string]interface{} {
+	return map[string]interface{}{listReplace: list(values...)}
+}
+
+func section(key string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{key: value}
+}
+
+func TestMergeSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     map[string]interface{}
+		settings map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "list replaces list",
+			base:     section("dirs", list("tmp", "vendor")),
+			settings: section("dirs", list("dist")),
+			expected: section("dirs", list("dist")),
+		},
+		{
+			name:     "append to list",
+			base:     section("dirs", list("tmp", "vendor")),
+			settings: section("dirs", appended("dist")),
+			expected: section("dirs", list("tmp", "vendor", "dist")),
+		},
+		{
+			name:     "replace list",
+			base:     section("dirs", list("tmp", "vendor")),
+			settings: section("dirs", replaced("dist")),
+			expected: section("dirs", list("dist")),
+		},
+		{
+			name:     "append without base list is kept for defaults",
+			base:     nil,
+			settings: section("dirs", appended("dist")),
+			expected: section("dirs", appended("dist")),
+		},
+		{
+			name:     "append to kept append",
+			base:     section("dirs", appended("dist")),
+			settings: section("dirs", appended("build")),
+			expected: section("dirs", appended("dist", "build")),
+		},
+		{
+			name:     "replace kept append",
+			base:     section("dirs", appended("dist")),
+			settings: section("dirs", replaced("build")),
+			expected: section("dirs", list("build")),
+		},
+		{
+			name:     "sections are merged recursively",
+			base:     section("build", map[string]interface{}{"command": "go build", "delay": "1s"}),
+			settings: section("build", map[string]interface{}{"delay": "2s"}),
+			expected: section("build", map[string]interface{}{"command": "go build", "delay": "2s"}),
+		},
+		{
+			name:     "value of a different type replaces the base",
+			base:     section("build", section("command", list("go", "build"))),
+			settings: section("build", section("command", "go build -race")),
+			expected: section("build", section("command", "go build -race")),
+		},
+		{
+			name:     "append in a nested section",
+			base:     section("watch", section("watch_patterns", list("*.go"))),
+			settings: section("watch", section("watch_patterns", appended("*.tmpl"))),
+			expected: section("watch", section("watch_patterns", list("*.go", "*.tmpl"))),
+		},
+	}
+
+	for _, test := range tests {
+		merged := mergeSettings(test.base, test.settings)
+		if !reflect.DeepEqual(merged, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, merged)
+		}
+	}
+}
+
+func TestAppendDefaults(t *testing.T) {
+	previous := defaults
+	defer func() { defaults = previous }()
+	defaults = map[string]interface{}{
+		"watch.watch_patterns": []string{"*.go"},
+		"build.binary_path":    "tmp/tmp-build",
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		expected map[string]interface{}
+		err      bool
+	}{
+		{
+			name:     "append to default",
+			settings: section("watch", section("watch_patterns", appended("*.tmpl"))),
+			expected: section("watch", section("watch_patterns", list("*.go", "*.tmpl"))),
+		},
+		{
+			name:     "lists are kept",
+			settings: section("watch", section("watch_patterns", list("*.tmpl"))),
+			expected: section("watch", section("watch_patterns", list("*.tmpl"))),
+		},
+		{
+			name:     "profiles are left to be applied over the configuration",
+			settings: section(profilesKey, section("race", section("watch", section("watch_patterns", appended("*.tmpl"))))),
+			expected: section(profilesKey, section("race", section("watch", section("watch_patterns", appended("*.tmpl"))))),
+		},
+		{
+			name:     "default is not a list",
+			settings: section("build", section("binary_path", appended("x"))),
+			err:      true,
+		},
+		{
+			name:     "no default",
+			settings: section("build", section("unknown", appended("x"))),
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		err := appendDefaults(test.settings, "")
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.settings, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, test.settings)
+		}
+	}
+}
+
+func TestReadSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner-extends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//noinspection ALL
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"base.yaml":         "watch:\n  ignored_directories: [tmp, vendor]\n  watch_patterns: {append: [\"*.sql\"]}\nbuild:\n  delay: 1s\n",
+		"team/runner.yaml":  "extends: ../base.yaml\nwatch:\n  ignored_directories: {append: [dist]}\n",
+		"service.yaml":      "extends: team/runner.yaml\nwatch:\n  watch_patterns: {append: [\"*.tmpl\"]}\nbuild:\n  delay: 2s\n",
+		"cycle-a.yaml":      "extends: cycle-b.yaml\n",
+		"cycle-b.yaml":      "extends: cycle-a.yaml\n",
+		"invalid-base.yaml": "extends: [base.yaml]\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	settings, chain, err := readSettings(filepath.Join(dir, "service.yaml"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedChain := []string{
+		filepath.Join(dir, "service.yaml"),
+		filepath.Join(dir, "team", "runner.yaml"),
+		filepath.Join(dir, "base.yaml"),
+	}
+	if !reflect.DeepEqual(chain, expectedChain) {
+		t.Errorf("expected files %v, got %v", expectedChain, chain)
+	}
+
+	expected := map[string]interface{}{
+		"watch": map[string]interface{}{
+			"ignored_directories": list("tmp", "vendor", "dist"),
+			"watch_patterns":      appended("*.sql", "*.tmpl"),
+		},
+		"build": section("delay", "2s"),
+	}
+	if !reflect.DeepEqual(settings, expected) {
+		t.Errorf("expected %v, got %v", expected, settings)
+	}
+
+	_, _, err = readSettings(filepath.Join(dir, "cycle-a.yaml"), nil)
+	if _, ok := err.(ExtendsCycleError); !ok {
+		t.Errorf("expected extends cycle error, got %v", err)
+	}
+
+	if _, _, err = readSettings(filepath.Join(dir, "invalid-base.yaml"), nil); err == nil {
+		t.Error("expected an error when extends is not a path")
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	previous := defaults
+	defer func() { defaults = previous }()
+	defaults = map[string]interface{}{"watch.watch_patterns": []string{"*.go"}}
+	defer viper.Reset()
+
+	fileSettings = map[string]interface{}{
+		"build": section("command", list("go", "build", ".")),
+		"watch": section("ignored_directories", list("tmp")),
+		profilesKey: map[string]interface{}{
+			"race": map[string]interface{}{
+				"build": section("command", "go build -race ."),
+				"watch": map[string]interface{}{
+					"ignored_directories": appended("dist"),
+					"watch_patterns":      appended("*.tmpl"),
+				},
+			},
+			"invalid": section("logging", section("level", "debug")),
+		},
+	}
+	defer func() { fileSettings = nil }()
+
+	viper.Set("profile", "race")
+	if err := applyProfile(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"build.command":             "go build -race .",
+		"watch.ignored_directories": list("tmp", "dist"),
+		"watch.watch_patterns":      list("*.go", "*.tmpl"),
+	}
+	for key, value := range expected {
+		if actual := viper.Get(key); !reflect.DeepEqual(actual, value) {
+			t.Errorf("%s: expected %v, got %v", key, value, actual)
+		}
+	}
+
+	viper.Set("profile", "unknown")
+	if _, ok := applyProfile().(UnknownProfileError); !ok {
+		t.Error("expected unknown profile error")
+	}
+
+	viper.Set("profile", "invalid")
+	if err := applyProfile(); err == nil {
+		t.Error("expected an error when the profile overrides logging")
+	}
+}
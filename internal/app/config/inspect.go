@@ -0,0 +1,151 @@
+package config
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sources of configuration values
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceProfile = "profile"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Keys returns keys of all configuration options, sorted.
+func Keys() []string {
+	keys := make([]string, 0)
+	for key := range options() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// options maps keys of configuration options to their types
+func options() map[string]reflect.Type {
+	types := make(map[string]reflect.Type)
+	collectOptions("", reflect.TypeOf(Config{}), types)
+
+	return types
+}
+
+func collectOptions(prefix string, t reflect.Type, types map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType && field.Type != commandType {
+			collectOptions(prefix+key+".", field.Type, types)
+			continue
+		}
+
+		types[prefix+key] = field.Type
+	}
+}
+
+// Sources tells where the effective value of each option comes from.
+func Sources(cmd *cobra.Command) (map[string]string, error) {
+	keys, err := fileKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	inFile := make(map[string]bool)
+	for _, key := range keys {
+		inFile[key] = true
+	}
+
+	profileKeys, err := activeProfileKeys()
+	if err != nil {
+		return nil, err
+	}
+	inProfile := make(map[string]bool)
+	for _, key := range profileKeys {
+		inProfile[key] = true
+	}
+
+	sources := make(map[string]string)
+	for _, key := range Keys() {
+		switch flag := cmd.Flags().Lookup(key); {
+		case flag != nil && flag.Changed:
+			sources[key] = SourceFlag
+		case os.Getenv(envName(key)) != "":
+			sources[key] = SourceEnv
+		case inProfile[key]:
+			sources[key] = SourceProfile
+		case inFile[key]:
+			sources[key] = SourceFile
+		default:
+			sources[key] = SourceDefault
+		}
+	}
+
+	return sources, nil
+}
+
+// envName returns the environment variable overriding the key
+func envName(key string) string {
+	return "RUNNER_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// fileKeys returns keys set in the config file and the files it extends
+func fileKeys() ([]string, error) {
+	file := viper.ConfigFileUsed()
+	if _, err := os.Stat(file); err != nil {
+		return nil, nil
+	}
+
+	settings, _, err := readSettings(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := appendDefaults(settings, ""); err != nil {
+		return nil, err
+	}
+
+	return settingsKeys(settings)
+}
+
+// activeProfileKeys returns keys set by the selected profile
+func activeProfileKeys() ([]string, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	profiles, _ := fileSettings[profilesKey].(map[string]interface{})
+	profile, ok := profiles[strings.ToLower(viper.GetString("profile"))].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	return settingsKeys(profile)
+}
+
+// settingsKeys returns keys of options set in the settings
+func settingsKeys(settings map[string]interface{}) ([]string, error) {
+	v := viper.New()
+	if err := v.MergeConfigMap(settings); err != nil {
+		return nil, err
+	}
+
+	// lists appended in profiles are kept as {append: [...]} until the profile is applied
+	keys := v.AllKeys()
+	for i, key := range keys {
+		keys[i] = strings.TrimSuffix(key, "."+listAppend)
+	}
+
+	return keys, nil
+}
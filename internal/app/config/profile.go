@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"sort"
+	"strings"
+)
+
+// NoProfile selects the configuration without any profile applied
+const NoProfile = "none"
+
+// profilesKey is the section of the config file defining profiles
+const profilesKey = "profiles"
+
+// profileSections are the sections a profile can override
+var profileSections = map[string]bool{"build": true, "run": true, "watch": true}
+
+// UnknownProfileError is returned when the selected profile is not defined in the config file.
+type UnknownProfileError struct {
+	Name string
+}
+
+func (e UnknownProfileError) Error() string {
+	return fmt.Sprintf("unknown profile %q", e.Name)
+}
+
+// readConfig reads the config file and applies the selected profile over it
+func readConfig() error {
+	if err := readFile(); err != nil {
+		return err
+	}
+
+	return applyProfile()
+}
+
+// applyProfile merges the selected profile over values of the config file like an extending file,
+// values of the profile replace values of the file. Environment variables and flags still take precedence.
+func applyProfile() error {
+	name := strings.ToLower(viper.GetString("profile"))
+	if name == "" || name == NoProfile {
+		return nil
+	}
+
+	profiles, _ := fileSettings[profilesKey].(map[string]interface{})
+	value, ok := profiles[name]
+	if !ok {
+		return UnknownProfileError{Name: name}
+	}
+
+	profile, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("profile %q must be a section overriding build, run and watch options", name)
+	}
+	for section, value := range profile {
+		if !profileSections[section] {
+			return fmt.Errorf("profile %q can't override %s, only build, run and watch", name, section)
+		}
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("profile %q: %s must be a section of options", name, section)
+		}
+	}
+
+	settings := mergeSettings(fileSettings, profile)
+	if err := appendDefaults(settings, ""); err != nil {
+		return fmt.Errorf("profile %q: %s", name, err.Error())
+	}
+
+	return setSettings(settings)
+}
+
+// Profiles returns names of profiles defined in the config file, sorted.
+func Profiles() []string {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	names := make([]string, 0)
+	for name := range viper.GetStringMap(profilesKey) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ActiveProfile returns the name of the selected profile, empty when no profile is selected.
+func ActiveProfile() string {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	return viper.GetString("profile")
+}
+
+// SwitchProfile selects the profile and loads the configuration again, the previous profile
+// is kept when the configuration can't be loaded.
+func SwitchProfile(name string) (*Config, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	previous := viper.GetString("profile")
+	viper.Set("profile", name)
+
+	err := readConfig()
+	if err == nil {
+		var config *Config
+		if config, err = unmarshal(); err == nil {
+			return config, nil
+		}
+	}
+
+	viper.Set("profile", previous)
+	_ = readConfig()
+
+	return nil, err
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"fmt"
+	"github.com/kolah/runner/internal/app"
+	"github.com/spf13/viper"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Problem is an issue found in the configuration, warnings don't prevent runner from starting.
+type Problem struct {
+	Key     string
+	Message string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+
+	return fmt.Sprintf("%s: %s: %s", level, p.Key, p.Message)
+}
+
+// Validate checks the configuration read by Read for unknown keys, invalid values, durations without units
+// and commands that can't be found.
+func Validate() ([]Problem, error) {
+	problems := make([]Problem, 0)
+
+	keys, err := fileKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	known := options()
+	for _, key := range keys {
+		option, prefix := key, ""
+		if strings.HasPrefix(key, "profiles.") {
+			// profiles.<name>.<option>
+			parts := strings.SplitN(key, ".", 3)
+			if len(parts) < 3 {
+				problems = append(problems, Problem{Key: key, Message: "profile must override build, run or watch options"})
+				continue
+			}
+			option, prefix = parts[2], parts[0]+"."+parts[1]+"."
+		}
+
+		if _, ok := known[option]; ok {
+			if prefix != "" && !profileSections[strings.SplitN(option, ".", 2)[0]] {
+				problems = append(problems, Problem{Key: key, Message: "profiles can only override build, run and watch options"})
+			}
+			continue
+		}
+
+		message := "unknown key"
+		if suggestion := suggest(option, Keys()); suggestion != "" {
+			message += fmt.Sprintf(", did you mean %s%s?", prefix, suggestion)
+		}
+		problems = append(problems, Problem{Key: key, Message: message})
+	}
+
+	durationProblems := 0
+	for _, key := range Keys() {
+		if known[key] == durationType {
+			found := validateDuration(key)
+			durationProblems += len(found)
+			problems = append(problems, found...)
+		}
+	}
+
+	enums := []struct {
+		key   string
+		parse func() error
+	}{
+		{"watch.backend", func() error { _, err := app.ParseWatchBackend(viper.GetString("watch.backend")); return err }},
+		{"run.debug_api", func() error { _, err := app.ParseDebugAPI(viper.GetString("run.debug_api")); return err }},
+		{"run.debug_strategy", func() error { _, err := app.ParseDebugStrategy(viper.GetString("run.debug_strategy")); return err }},
+		{"run.debug_on_change", func() error { _, err := app.ParseDebugOnChange(viper.GetString("run.debug_on_change")); return err }},
+		{"run.stdin", func() error { _, err := app.ParseStdinMode(viper.GetString("run.stdin")); return err }},
+		{"logging.level", func() error { _, err := app.ParseLevel(viper.GetString("logging.level")); return err }},
+	}
+	for _, enum := range enums {
+		if err := enum.parse(); err != nil {
+			problems = append(problems, Problem{Key: enum.key, Message: err.Error()})
+		}
+	}
+
+	config, err := decode()
+	if err != nil {
+		// invalid durations are already reported
+		if durationProblems == 0 {
+			problems = append(problems, Problem{Key: "config", Message: err.Error()})
+		}
+		return problems, nil
+	}
+
+	failed := make(map[string]bool)
+	for _, commandErr := range validateCommands(config) {
+		failed[commandErr.Key] = true
+		problems = append(problems, Problem{Key: commandErr.Key, Message: commandErr.Err.Error()})
+	}
+
+	// debug commands are derived from the run command, an invalid debug API is already reported
+	_, apiErr := app.ParseDebugAPI(config.Run.DebugAPI)
+	if !failed["run.command"] && apiErr == nil {
+		if err := resolveDebugCommands(config); err != nil {
+			problems = append(problems, Problem{Key: "run.debug_command", Message: err.Error()})
+		}
+	}
+
+	commands := []struct {
+		key     string
+		command app.Command
+		warning bool
+	}{
+		{"build.command", config.Build.Command, false},
+		{"run.command", config.Run.Command, false},
+		// only needed in debug mode
+		{"run.debug_command", config.Run.DebugCommand, true},
+		{"run.debug_attach_command", config.Run.DebugAttach, true},
+	}
+	for _, c := range commands {
+		args, err := c.command.Argv(CommandVars(config))
+		if err != nil || failed[c.key] {
+			continue
+		}
+		if problem := validateCommand(c.key, args); problem != nil {
+			problem.Warning = problem.Warning || c.warning
+			problems = append(problems, *problem)
+		}
+	}
+
+	dirs := []struct {
+		key string
+		dir string
+	}{
+		{"build.dir", config.Build.Dir},
+		{"run.dir", config.Run.Dir},
+	}
+	for _, d := range dirs {
+		if info, err := os.Stat(d.dir); d.dir != "" && (err != nil || !info.IsDir()) {
+			problems = append(problems, Problem{Key: d.key, Message: fmt.Sprintf("directory %s doesn't exist", d.dir)})
+		}
+	}
+
+	return problems, nil
+}
+
+// validateDuration reports durations given as plain numbers, they would be read as nanoseconds
+func validateDuration(key string) []Problem {
+	switch value := viper.Get(key).(type) {
+	case string:
+		if _, err := time.ParseDuration(value); err != nil {
+			return []Problem{{Key: key, Message: fmt.Sprintf("invalid duration %q, use a unit like 650ms or 5s", value)}}
+		}
+	case int, int64, float64:
+		if reflect.ValueOf(value).Convert(reflect.TypeOf(float64(0))).Float() != 0 {
+			return []Problem{{Key: key, Message: fmt.Sprintf("duration %v has no unit and is read as nanoseconds, use a unit like 650ms or 5s", value)}}
+		}
+	}
+
+	return nil
+}
+
+// validateCommand checks that the program of the command can be found, programs given by path
+// are not checked, they may be created by the build
+func validateCommand(key string, args []string) *Problem {
+	if strings.ContainsRune(args[0], '/') {
+		return nil
+	}
+
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return &Problem{Key: key, Message: fmt.Sprintf("%s not found in PATH", args[0])}
+	}
+
+	return nil
+}
+
+// suggest returns the known key closest to the unknown one, empty when none is close enough
+func suggest(key string, known []string) string {
+	best, bestDistance := "", len(key)/2+1
+	for _, k := range known {
+		if d := distance(key, k); d < bestDistance {
+			best, bestDistance = k, d
+		}
+	}
+
+	return best
+}
+
+// distance is the Levenshtein distance of the strings
+func distance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,282 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shirou/gopsutil/net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DebugStrategy defines how the debugger is started in debug mode.
+type DebugStrategy string
+
+const (
+	// DebugExec stops the application and starts it under the debug command
+	DebugExec DebugStrategy = "exec"
+	// DebugAttach attaches the debugger to the running application
+	DebugAttach DebugStrategy = "attach"
+)
+
+// DebugAPI defines the protocol debugger clients use.
+type DebugAPI string
+
+const (
+	// DebugAPIJSONRPC runs the headless delve server with JSON-RPC API
+	DebugAPIJSONRPC DebugAPI = "jsonrpc"
+	// DebugAPIDAP runs the delve Debug Adapter Protocol server, the IDE launches the application through it
+	DebugAPIDAP DebugAPI = "dap"
+)
+
+// DebugOnChange defines how file changes are handled in debug mode.
+type DebugOnChange string
+
+const (
+	// DebugOnChangeIgnore ignores changes while debugging
+	DebugOnChangeIgnore DebugOnChange = "ignore"
+	// DebugOnChangeRebuild rebuilds the application and restarts the debugger
+	DebugOnChangeRebuild DebugOnChange = "rebuild"
+	// DebugOnChangeQueue keeps changes pending until debug mode is left
+	DebugOnChangeQueue DebugOnChange = "queue"
+)
+
+// detachTimeout limits how long the debugger has to detach before it's killed
+const detachTimeout = 5 * time.Second
+
+// debugMonitorInterval is how often the debugger process and its connections are checked
+const debugMonitorInterval = time.Second
+
+// ParseDebugStrategy takes a string and returns the debug strategy constant.
+func ParseDebugStrategy(strategy string) (DebugStrategy, error) {
+	switch s := DebugStrategy(strings.ToLower(strategy)); s {
+	case DebugExec, DebugAttach:
+		return s, nil
+	case "":
+		return DebugExec, nil
+	}
+
+	return DebugExec, fmt.Errorf("not a valid debug strategy: %q", strategy)
+}
+
+// ParseDebugAPI takes a string and returns the debug API constant.
+func ParseDebugAPI(api string) (DebugAPI, error) {
+	switch a := DebugAPI(strings.ToLower(api)); a {
+	case DebugAPIJSONRPC, DebugAPIDAP:
+		return a, nil
+	case "":
+		return DebugAPIJSONRPC, nil
+	}
+
+	return DebugAPIJSONRPC, fmt.Errorf("not a valid debug API: %q", api)
+}
+
+// DefaultDebugCommand returns the delve command debugging the application started by run command,
+// runArgs are the program and its arguments.
+func DefaultDebugCommand(api DebugAPI, port int, runArgs []string) (Command, error) {
+	if api == DebugAPIDAP {
+		return NewCommand(fmt.Sprintf("dlv dap --listen=:%d", port)), nil
+	}
+
+	if len(runArgs) == 0 {
+		return Command{}, errors.New("run command is empty")
+	}
+
+	args := []string{"dlv", "--headless", fmt.Sprintf("--listen=:%d", port), "--api-version=2", "exec", runArgs[0]}
+	if len(runArgs) > 1 {
+		args = append(append(args, "--"), runArgs[1:]...)
+	}
+
+	return NewArgsCommand(args...), nil
+}
+
+// DefaultDebugAttachCommand returns the delve command attaching to the application, the headless
+// server accepts both JSON-RPC and DAP clients.
+func DefaultDebugAttachCommand(port int) Command {
+	return NewCommand(fmt.Sprintf("dlv attach --headless --listen=:%d --api-version=2 --accept-multiclient --continue", port))
+}
+
+// ParseDebugOnChange takes a string and returns the constant describing how changes are handled in debug mode.
+func ParseDebugOnChange(onChange string) (DebugOnChange, error) {
+	switch c := DebugOnChange(strings.ToLower(onChange)); c {
+	case DebugOnChangeIgnore, DebugOnChangeRebuild, DebugOnChangeQueue:
+		return c, nil
+	case "":
+		return DebugOnChangeIgnore, nil
+	}
+
+	return DebugOnChangeIgnore, fmt.Errorf("not a valid debug_on_change value: %q", onChange)
+}
+
+type DebugOpts struct {
+	command          Command
+	buildBeforeDebug bool
+	strategy         DebugStrategy
+	attachCommand    Command
+	onChange         DebugOnChange
+	idleTimeout      time.Duration
+}
+
+func NewDebugOptions(command Command, buildBeforeDebug bool, strategy DebugStrategy, attachCommand Command, onChange DebugOnChange, idleTimeout time.Duration) DebugOpts {
+	return DebugOpts{
+		command:          command,
+		buildBeforeDebug: buildBeforeDebug,
+		strategy:         strategy,
+		attachCommand:    attachCommand,
+		onChange:         onChange,
+		idleTimeout:      idleTimeout,
+	}
+}
+
+// attach starts the attach command for the running application, the caller must hold the lock
+func (r *Runner) attach() {
+	if r.worker == nil || !r.worker.Running() {
+		r.logger.Info("Application is not running, debugger not attached\n")
+		return
+	}
+
+	command := r.options.debug.attachCommand.WithArg(strconv.Itoa(r.worker.Pid()))
+	args, err := r.argv(command)
+	if err != nil {
+		r.logger.Infof("Failed to run \"%s\", %s\n", command, err.Error())
+		return
+	}
+	r.logger.Infof("Attaching debugger to process %d\n", r.worker.Pid())
+
+	r.debugger = NewWorker(args, r.options.runDir, StdinNone, "", r.logger, r.appLogger)
+	if err := r.debugger.Run(); err != nil {
+		r.logger.Infof("Failed to run \"%s\", %s", command, err.Error())
+		r.debugger = nil
+		return
+	}
+	r.startDebugMonitor(r.debugger)
+}
+
+// detach stops the attached debugger and lets the application continue, the caller must hold the lock
+func (r *Runner) detach() {
+	if r.debugger == nil {
+		return
+	}
+
+	r.stopDebugMonitor()
+	r.logger.Info("Detaching debugger\n")
+	r.debugger.Interrupt(detachTimeout)
+	r.debugger = nil
+}
+
+// handleDebugChange decides what to do with file changes in debug mode, it tells whether to rebuild
+func (r *Runner) handleDebugChange(changes *ChangeSet) bool {
+	switch r.options.debug.onChange {
+	case DebugOnChangeRebuild:
+		r.logger.Info("Rebuilding while debugging, the debugger will be restarted\n")
+		return true
+	case DebugOnChangeQueue:
+		r.Lock()
+		r.queued.Merge(changes)
+		r.Unlock()
+		r.logger.Info("Changes queued until debug mode is left\n")
+		return false
+	}
+
+	r.logger.Debug("ignoring code changes while debugging\n")
+	return false
+}
+
+// startDebugMonitor starts watching the debugger process, the caller must hold the lock
+func (r *Runner) startDebugMonitor(debugger *Worker) {
+	r.stopDebugMonitor()
+
+	done := make(chan bool)
+	r.debugMonitor = done
+	go r.monitorDebugger(debugger, done)
+}
+
+// stopDebugMonitor stops watching the debugger process, the caller must hold the lock
+func (r *Runner) stopDebugMonitor() {
+	if r.debugMonitor != nil {
+		close(r.debugMonitor)
+		r.debugMonitor = nil
+	}
+}
+
+// monitorDebugger switches back to rebuild mode when the debugger exits, its client disconnects
+// or no client connects within the idle timeout
+func (r *Runner) monitorDebugger(debugger *Worker, done chan bool) {
+	ticker := time.NewTicker(debugMonitorInterval)
+	defer ticker.Stop()
+
+	connected := false
+	idleSince := time.Now()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		reason := ""
+		clients, err := debuggerClients(debugger.Pid())
+		switch {
+		case !debugger.Running():
+			reason = "the debugger exited"
+		case err != nil:
+			r.logger.Debugf("Failed to check debugger connections: %s\n", err.Error())
+		case clients > 0:
+			connected = true
+			idleSince = time.Now()
+		case connected:
+			reason = "the debugger client disconnected"
+		}
+
+		idleTimeout := r.options.debug.idleTimeout
+		if reason == "" && idleTimeout > 0 && time.Since(idleSince) >= idleTimeout {
+			reason = fmt.Sprintf("no debugger client connected for %s", idleTimeout)
+		}
+
+		if reason == "" {
+			continue
+		}
+
+		r.Lock()
+		select {
+		case <-done:
+			// mode was switched meanwhile
+			r.Unlock()
+			return
+		default:
+			r.stopDebugMonitor()
+		}
+		r.Unlock()
+
+		r.logger.Infof("Leaving debug mode: %s, switching back to %s\n", reason, ModeRebuild)
+		if err := r.SwitchMode(ModeRebuild); err != nil {
+			r.logger.Infof("Build error: %s\n", err.Error())
+		}
+		return
+	}
+}
+
+// debuggerClients counts connections established to ports the debugger process listens on
+func debuggerClients(pid int) (int, error) {
+	connections, err := net.ConnectionsPid("tcp", int32(pid))
+	if err != nil {
+		return 0, err
+	}
+
+	listening := make(map[uint32]bool)
+	for _, c := range connections {
+		if c.Status == "LISTEN" {
+			listening[c.Laddr.Port] = true
+		}
+	}
+
+	clients := 0
+	for _, c := range connections {
+		if c.Status == "ESTABLISHED" && listening[c.Laddr.Port] {
+			clients++
+		}
+	}
+
+	return clients, nil
+}
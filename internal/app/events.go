@@ -0,0 +1,84 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventBuildStarted  EventType = "build_started"
+	EventBuildFinished EventType = "build_finished"
+	EventBuildFailed   EventType = "build_failed"
+	EventStarted       EventType = "started"
+	EventModeChanged   EventType = "mode_changed"
+	EventPaused        EventType = "paused"
+	EventResumed       EventType = "resumed"
+)
+
+// subscriberBuffer is the number of events kept for a subscriber that doesn't keep up,
+// newer events are dropped for that subscriber when the buffer is full
+const subscriberBuffer = 32
+
+// Event describes a change of runner state.
+type Event struct {
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message,omitempty"`
+	// Files are the changes that triggered the build
+	Files []FileChange `json:"files,omitempty"`
+}
+
+func NewEvent(eventType EventType, message string) Event {
+	return Event{Type: eventType, Time: time.Now(), Message: message}
+}
+
+func newBuildEvent(eventType EventType, message string, changes *ChangeSet) Event {
+	e := NewEvent(eventType, message)
+	e.Files = changes.Changes()
+
+	return e
+}
+
+// EventBus broadcasts events to subscribers.
+type EventBus struct {
+	sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving published events and a function cancelling the subscription.
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	b.Lock()
+	defer b.Unlock()
+
+	c := make(chan Event, subscriberBuffer)
+	b.subscribers[c] = struct{}{}
+
+	return c, func() {
+		b.Lock()
+		defer b.Unlock()
+
+		if _, ok := b.subscribers[c]; ok {
+			delete(b.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// Publish sends the event to all subscribers without blocking.
+func (b *EventBus) Publish(e Event) {
+	b.Lock()
+	defer b.Unlock()
+
+	for c := range b.subscribers {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
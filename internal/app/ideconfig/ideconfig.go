@@ -0,0 +1,149 @@
+// Package ideconfig generates IDE debug configurations connecting to the debugger started by runner.
+package ideconfig
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/kolah/runner/internal/app"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// WorkspaceFolder is the VS Code variable pointing to the opened project.
+const WorkspaceFolder = "${workspaceFolder}"
+
+type Options struct {
+	Name     string
+	API      app.DebugAPI
+	Strategy app.DebugStrategy
+	Host     string
+	Port     int
+	// Program is the path of the built binary, relative to the project root or absolute
+	Program string
+	// LocalRoot is the project root on the IDE side
+	LocalRoot string
+	// RemoteRoot is the project root where runner runs, empty when it's the same as LocalRoot
+	RemoteRoot string
+}
+
+type substitutePath struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type vsCodeConfiguration struct {
+	Name           string           `json:"name"`
+	Type           string           `json:"type"`
+	Request        string           `json:"request"`
+	Mode           string           `json:"mode"`
+	DebugAdapter   string           `json:"debugAdapter"`
+	Program        string           `json:"program,omitempty"`
+	Host           string           `json:"host"`
+	Port           int              `json:"port"`
+	SubstitutePath []substitutePath `json:"substitutePath,omitempty"`
+}
+
+type vsCodeLaunch struct {
+	Version        string                `json:"version"`
+	Configurations []vsCodeConfiguration `json:"configurations"`
+}
+
+// VSCode returns launch.json contents for the Go extension.
+func VSCode(o Options) ([]byte, error) {
+	c := vsCodeConfiguration{
+		Name:         o.Name,
+		Type:         "go",
+		Request:      "attach",
+		Mode:         "remote",
+		DebugAdapter: "dlv-dap",
+		Host:         o.Host,
+		Port:         o.Port,
+	}
+
+	switch {
+	case o.API == app.DebugAPIJSONRPC:
+		c.DebugAdapter = "legacy"
+	case o.Strategy == app.DebugExec:
+		// the DAP server doesn't start the application, the IDE launches the built binary through it
+		c.Request = "launch"
+		c.Mode = "exec"
+		c.Program = o.remoteProgram()
+	}
+
+	if o.RemoteRoot != "" {
+		c.SubstitutePath = []substitutePath{{From: o.LocalRoot, To: o.RemoteRoot}}
+	}
+
+	launch := vsCodeLaunch{Version: "0.2.0", Configurations: []vsCodeConfiguration{c}}
+
+	return json.MarshalIndent(launch, "", "    ")
+}
+
+type goLandOption struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type goLandMethod struct {
+	Version string `xml:"v,attr"`
+}
+
+type goLandConfiguration struct {
+	Default     string         `xml:"default,attr"`
+	Name        string         `xml:"name,attr"`
+	Type        string         `xml:"type,attr"`
+	FactoryName string         `xml:"factoryName,attr"`
+	Options     []goLandOption `xml:"option"`
+	Method      goLandMethod   `xml:"method"`
+}
+
+type goLandComponent struct {
+	XMLName       xml.Name            `xml:"component"`
+	Name          string              `xml:"name,attr"`
+	Configuration goLandConfiguration `xml:"configuration"`
+}
+
+// GoLand returns a shared "Go Remote" run configuration, usually stored in the .run directory.
+func GoLand(o Options) ([]byte, error) {
+	if o.API == app.DebugAPIDAP && o.Strategy == app.DebugExec {
+		return nil, errors.New("GoLand connects to the headless debugger, set run.debug_api to jsonrpc or use attach strategy")
+	}
+	if o.RemoteRoot != "" {
+		return nil, errors.New("the GoLand Go Remote configuration has no path mappings, " +
+			"mount the sources at the same path as on the IDE side")
+	}
+
+	component := goLandComponent{
+		Name: "ProjectRunConfigurationManager",
+		Configuration: goLandConfiguration{
+			Default:     "false",
+			Name:        o.Name,
+			Type:        "GoRemoteDebugConfigurationType",
+			FactoryName: "Go Remote",
+			Options: []goLandOption{
+				{Name: "disconnectOption", Value: "LEAVE"},
+				{Name: "host", Value: o.Host},
+				{Name: "port", Value: strconv.Itoa(o.Port)},
+			},
+			Method: goLandMethod{Version: "2"},
+		},
+	}
+
+	return xml.MarshalIndent(component, "", "  ")
+}
+
+// remoteProgram returns the path of the binary as seen by the debugger
+func (o Options) remoteProgram() string {
+	if filepath.IsAbs(o.Program) {
+		return o.Program
+	}
+
+	root := o.RemoteRoot
+	if root == "" {
+		root = o.LocalRoot
+	}
+
+	return path.Join(root, filepath.ToSlash(o.Program))
+}
@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package app
+
+import "os"
+
+// inode is not available, changes are detected by modification time and size only
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const keyboardHelp = "Keys: [r] rebuild, [R] restart, [d] toggle debug mode, [p] pause/resume, " +
+	"[c] clear screen, [l] last build error, [q] quit, [?] help\n"
+
+// Keyboard handles single key commands typed in the terminal runner is attached to.
+type Keyboard struct {
+	input    io.Reader
+	runner   *Runner
+	shutdown *Shutdown
+	logger   Logger
+}
+
+func NewKeyboard(input io.Reader, runner *Runner, shutdown *Shutdown, logger Logger) *Keyboard {
+	return &Keyboard{
+		input:    input,
+		runner:   runner,
+		shutdown: shutdown,
+		logger:   logger,
+	}
+}
+
+// Start reads keys from the input until it's closed or shutdown is requested.
+func (k *Keyboard) Start() {
+	k.logger.Info(keyboardHelp)
+
+	go func() {
+		reader := bufio.NewReader(k.input)
+		for {
+			key, _, err := reader.ReadRune()
+			if err != nil {
+				k.logger.Debugf("Keyboard: stopped reading input: %s\n", err.Error())
+				return
+			}
+
+			select {
+			case <-k.shutdown.Requested():
+				return
+			default:
+				k.handleKey(key)
+			}
+		}
+	}()
+}
+
+func (k *Keyboard) handleKey(key rune) {
+	switch key {
+	case 'r':
+		if err := k.runner.Rebuild(); err != nil {
+			k.logger.Infof("Build error: %s\n", err.Error())
+		}
+	case 'R':
+		k.runner.Restart()
+	case 'd':
+		mode := ModeDebug
+		if k.runner.Mode() == ModeDebug {
+			mode = ModeRebuild
+		}
+		if err := k.runner.SwitchMode(mode); err != nil {
+			k.logger.Infof("Build error: %s\n", err.Error())
+		}
+	case 'p':
+		if k.runner.Paused() {
+			k.runner.Resume(true)
+		} else {
+			k.runner.Pause()
+		}
+	case 'c':
+		fmt.Print("\033[H\033[2J")
+	case 'l':
+		if buildErr := k.runner.LastBuildError(); buildErr != "" {
+			k.logger.Infof("Last build error:\n%s", buildErr)
+		} else {
+			k.logger.Info("Last build succeeded\n")
+		}
+	case 'q':
+		k.logger.Info("Quitting...\n")
+		k.shutdown.Request()
+	case '?':
+		k.logger.Info(keyboardHelp)
+	}
+}
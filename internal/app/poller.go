@@ -0,0 +1,174 @@
+package app
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileState is the part of file metadata used to detect changes
+type fileState struct {
+	modTime time.Time
+	size    int64
+	inode   uint64
+}
+
+// poller detects changes by comparing snapshots of watched files taken at an interval,
+// it's used where file system events are not available, e.g. on network file systems and Docker bind mounts.
+type poller struct {
+	sync.Mutex
+	watcher   *Watcher
+	interval  time.Duration
+	dirs      []string
+	recursive bool
+	files     map[string]fileState
+	// subdirs are subdirectories of scanned directories, new ones are added to the watcher when not recursive
+	subdirs map[string]bool
+}
+
+// defaultPollInterval is used when the configured interval is not positive
+const defaultPollInterval = 500 * time.Millisecond
+
+// newPoller creates a poller scanning the directories, subdirectories are scanned only when recursive
+func newPoller(watcher *Watcher, interval time.Duration, dirs []string, recursive bool) *poller {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	return &poller{
+		watcher:   watcher,
+		interval:  interval,
+		dirs:      dirs,
+		recursive: recursive,
+		files:     make(map[string]fileState),
+		subdirs:   make(map[string]bool),
+	}
+}
+
+// addDirs adds directories to scan, files already in them are not reported as created
+func (p *poller) addDirs(dirs []string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.dirs = append(p.dirs, dirs...)
+	for _, dir := range dirs {
+		p.scanDir(dir, p.files, p.subdirs)
+	}
+}
+
+func (p *poller) start(quit chan bool) {
+	p.Lock()
+	p.files, p.subdirs = p.scan()
+	p.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.poll()
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+// poll takes a new snapshot and dispatches events for the differences
+func (p *poller) poll() {
+	p.Lock()
+	defer p.Unlock()
+
+	files, subdirs := p.scan()
+
+	p.watcher.Lock()
+	defer p.watcher.Unlock()
+
+	// subdirectories are only found when not recursive, they are watched like directories created in watched ones
+	for dir := range subdirs {
+		if !p.subdirs[dir] && !p.watcher.skipDir(dir) {
+			go p.watcher.watchNewDir(dir)
+		}
+	}
+	p.subdirs = subdirs
+
+	for path, state := range files {
+		previous, ok := p.files[path]
+		switch {
+		case !ok:
+			p.watcher.dispatch(fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case previous.inode != state.inode:
+			// replaced, e.g. by an editor writing to a temporary file and renaming it
+			p.watcher.dispatch(fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case !previous.modTime.Equal(state.modTime) || previous.size != state.size:
+			p.watcher.dispatch(fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+	}
+
+	for path := range p.files {
+		if _, ok := files[path]; !ok {
+			p.watcher.dispatch(fsnotify.Event{Name: path, Op: fsnotify.Remove})
+		}
+	}
+
+	p.files = files
+}
+
+// scan collects the state of files matching watch patterns in scanned directories, and subdirectories
+// of scanned directories when not recursive. The caller must hold the lock.
+func (p *poller) scan() (map[string]fileState, map[string]bool) {
+	files := make(map[string]fileState)
+	subdirs := make(map[string]bool)
+
+	for _, dir := range p.dirs {
+		p.scanDir(dir, files, subdirs)
+	}
+
+	return files, subdirs
+}
+
+func (p *poller) scanDir(dir string, files map[string]fileState, subdirs map[string]bool) {
+	if !p.recursive {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, info := range infos {
+			path := filepath.Join(dir, info.Name())
+			if info.IsDir() {
+				subdirs[path] = true
+			}
+			p.addFile(path, info, files)
+		}
+		return
+	}
+
+	visited := make(map[string]bool)
+	_ = walkTree(dir, p.watcher.options.followSymlinks, visited, func(path string, real string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the file could be removed while scanning
+			return nil
+		}
+
+		if info.IsDir() && p.watcher.skipDir(path) {
+			return filepath.SkipDir
+		}
+
+		p.addFile(path, info, files)
+
+		return nil
+	})
+}
+
+func (p *poller) addFile(path string, info os.FileInfo, files map[string]fileState) {
+	if info.IsDir() || !p.watcher.fileMatches(&path) {
+		return
+	}
+
+	files[path] = fileState{modTime: info.ModTime(), size: info.Size(), inode: inode(info)}
+}
@@ -0,0 +1,412 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// operations of remote changes
+const (
+	RemoteCreate = "create"
+	RemoteWrite  = "write"
+	RemoteRemove = "remove"
+	RemoteChmod  = "chmod"
+)
+
+// RemoteChange is a file change sent by the agent, encoded as a single line of JSON.
+type RemoteChange struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// HasContent tells whether the content is included, so empty files can be distinguished
+	HasContent bool        `json:"has_content,omitempty"`
+	Content    []byte      `json:"content,omitempty"`
+	Mode       os.FileMode `json:"mode,omitempty"`
+}
+
+// ParseRemoteAddress splits an address like tcp://host:port or unix:///path/to/socket into network and address.
+func ParseRemoteAddress(address string) (string, string, error) {
+	parts := strings.SplitN(address, "://", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("not a valid remote address: %q, expected tcp://host:port or unix:///path", address)
+	}
+
+	switch parts[0] {
+	case "tcp", "unix":
+		return parts[0], parts[1], nil
+	}
+
+	return "", "", fmt.Errorf("not a valid remote address network: %q", parts[0])
+}
+
+// remoteOp maps fsnotify operation to remote change operation
+func remoteOp(op fsnotify.Op) string {
+	switch {
+	case op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		return RemoteRemove
+	case op&fsnotify.Create != 0:
+		return RemoteCreate
+	case op&fsnotify.Write != 0:
+		return RemoteWrite
+	}
+
+	return RemoteChmod
+}
+
+// fsnotifyOp maps remote change operation to fsnotify operation
+func fsnotifyOp(op string) (fsnotify.Op, error) {
+	switch op {
+	case RemoteCreate:
+		return fsnotify.Create, nil
+	case RemoteWrite:
+		return fsnotify.Write, nil
+	case RemoteRemove:
+		return fsnotify.Remove, nil
+	case RemoteChmod:
+		return fsnotify.Chmod, nil
+	}
+
+	return 0, fmt.Errorf("unknown operation %q", op)
+}
+
+// localPath converts the slash separated path relative to the project root into a local path,
+// paths leaving the project root are rejected
+func localPath(remotePath string) (string, error) {
+	clean := path.Clean(remotePath)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("path %q is outside of the project", remotePath)
+	}
+
+	return filepath.FromSlash(clean), nil
+}
+
+// startRemote listens for changes sent by agents
+func (w *Watcher) startRemote() error {
+	network, address, err := ParseRemoteAddress(w.options.remoteListen)
+	if err != nil {
+		return err
+	}
+
+	if network == "unix" {
+		// remove the socket left by a previous run
+		_ = os.Remove(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+
+	w.logger.Infof("Watcher: receiving changes from agents on %s\n", w.options.remoteListen)
+
+	w.Lock()
+	w.remote = listener
+	w.remoteConns = make(map[net.Conn]bool)
+	quit := w.quit
+	w.Unlock()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-quit:
+					return
+				default:
+					continue
+				}
+			}
+
+			go w.handleRemote(conn)
+		}
+	}()
+
+	return nil
+}
+
+func (w *Watcher) handleRemote(conn net.Conn) {
+	//noinspection ALL
+	defer conn.Close()
+
+	// connections are closed when the watcher stops
+	w.Lock()
+	if w.remoteConns == nil {
+		w.Unlock()
+		return
+	}
+	w.remoteConns[conn] = true
+	w.Unlock()
+
+	defer func() {
+		w.Lock()
+		delete(w.remoteConns, conn)
+		w.Unlock()
+	}()
+
+	w.logger.Info("Watcher: agent connected\n")
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var change RemoteChange
+		if err := decoder.Decode(&change); err != nil {
+			w.logger.Infof("Watcher: agent disconnected: %s\n", err.Error())
+			return
+		}
+
+		if err := w.applyRemoteChange(change); err != nil {
+			w.logger.Infof("Watcher: unable to apply change of \"%s\": %s\n", change.Path, err.Error())
+		}
+	}
+}
+
+// applyRemoteChange updates the local tree when the change includes content and dispatches the event
+func (w *Watcher) applyRemoteChange(change RemoteChange) error {
+	name, err := localPath(change.Path)
+	if err != nil {
+		return err
+	}
+
+	op, err := fsnotifyOp(change.Op)
+	if err != nil {
+		return err
+	}
+
+	if change.HasContent {
+		w.Lock()
+		err := w.checkRemoteWrite(name)
+		w.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case op == fsnotify.Remove && change.HasContent:
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	case change.HasContent:
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		// setuid, setgid and sticky bits sent by an agent are not applied
+		mode := change.Mode.Perm()
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := ioutil.WriteFile(name, change.Content, mode); err != nil {
+			return err
+		}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.logger.Debugf("Watcher: remote change %s \"%s\"\n", change.Op, name)
+	w.dispatch(fsnotify.Event{Name: name, Op: op})
+
+	return nil
+}
+
+// checkRemoteWrite tells whether the file sent by an agent can be written or removed, it has to be watched
+// and can't be reached through a symlink
+func (w *Watcher) checkRemoteWrite(name string) error {
+	if !w.options.remoteContents {
+		return errors.New("file contents are not accepted, set watch.remote_accept_contents to write them")
+	}
+
+	if !w.fileMatches(&name) {
+		return errors.New("file doesn't match watch patterns or is ignored")
+	}
+
+	watched := false
+	for _, dir := range w.watchDirs.Values() {
+		dir = filepath.Clean(dir)
+		if dir == "." || strings.HasPrefix(name, dir+string(filepath.Separator)) {
+			watched = true
+			break
+		}
+	}
+	if !watched {
+		return errors.New("file is not in a watched directory")
+	}
+
+	for dir := filepath.Dir(name); dir != "."; dir = filepath.Dir(dir) {
+		if w.skipDir(dir) {
+			return fmt.Errorf("directory \"%s\" is ignored", dir)
+		}
+	}
+
+	current := ""
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("\"%s\" is a symlink", current)
+		}
+	}
+
+	return nil
+}
+
+// Agent sends changes detected by its watcher to a runner using the remote watch backend.
+type Agent struct {
+	network  string
+	address  string
+	root     string
+	contents bool
+	watcher  *Watcher
+	changes  chan fsnotify.Event
+	quit     chan bool
+	logger   Logger
+}
+
+// agentQueue is the number of changes kept while the agent is connecting
+const agentQueue = 1024
+
+// NewAgent creates an agent sending changes to the address, file contents are included when contents is set.
+func NewAgent(address string, watcher *Watcher, contents bool, logger Logger) (*Agent, error) {
+	network, address, err := ParseRemoteAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		network:  network,
+		address:  address,
+		root:     root,
+		contents: contents,
+		watcher:  watcher,
+		changes:  make(chan fsnotify.Event, agentQueue),
+		quit:     make(chan bool),
+		logger:   logger,
+	}, nil
+}
+
+func (a *Agent) Start() error {
+	a.watcher.AddListener(func(event fsnotify.Event) {
+		select {
+		case a.changes <- event:
+		default:
+			a.logger.Infof("Agent: queue full, dropping change of \"%s\"\n", event.Name)
+		}
+	})
+
+	if err := a.watcher.Start(); err != nil {
+		return err
+	}
+
+	go a.sendLoop()
+
+	return nil
+}
+
+func (a *Agent) Stop() error {
+	close(a.quit)
+
+	return a.watcher.Stop()
+}
+
+func (a *Agent) sendLoop() {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	for {
+		var event fsnotify.Event
+		select {
+		case event = <-a.changes:
+		case <-a.quit:
+			return
+		}
+
+		change, err := a.change(event)
+		if err != nil {
+			a.logger.Infof("Agent: skipping change of \"%s\": %s\n", event.Name, err.Error())
+			continue
+		}
+
+		// retry once on a fresh connection, the runner could have been restarted
+		for attempt := 0; attempt < 2; attempt++ {
+			if conn == nil {
+				if conn, err = net.Dial(a.network, a.address); err != nil {
+					conn = nil
+					break
+				}
+				a.logger.Infof("Agent: connected to %s://%s\n", a.network, a.address)
+			}
+
+			if err = json.NewEncoder(conn).Encode(change); err == nil {
+				break
+			}
+			_ = conn.Close()
+			conn = nil
+		}
+
+		if err != nil {
+			a.logger.Infof("Agent: unable to send change of \"%s\": %s\n", event.Name, err.Error())
+			continue
+		}
+		a.logger.Debugf("Agent: sent %s \"%s\"\n", change.Op, change.Path)
+	}
+}
+
+// change converts the event into a remote change with the path relative to the project root
+func (a *Agent) change(event fsnotify.Event) (RemoteChange, error) {
+	name, err := filepath.Abs(event.Name)
+	if err != nil {
+		return RemoteChange{}, err
+	}
+
+	relative, err := filepath.Rel(a.root, name)
+	if err != nil {
+		return RemoteChange{}, err
+	}
+	if relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return RemoteChange{}, errors.New("file is outside of the project")
+	}
+
+	change := RemoteChange{Op: remoteOp(event.Op), Path: filepath.ToSlash(relative)}
+	if !a.contents {
+		return change, nil
+	}
+
+	change.HasContent = true
+	if change.Op == RemoteRemove {
+		return change, nil
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		// removed in the meantime
+		change.Op = RemoteRemove
+		return change, nil
+	}
+	if change.Content, err = ioutil.ReadFile(name); err != nil {
+		return RemoteChange{}, err
+	}
+	change.Mode = info.Mode().Perm()
+
+	return change, nil
+}
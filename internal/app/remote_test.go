@@ -0,0 +1,168 @@
+package app
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// inDir runs the test with the temporary directory as the working directory, remote paths are relative to it
+func inDir(t *testing.T, test func(dir string)) {
+	dir, err := ioutil.TempDir("", "runner-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//noinspection ALL
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	//noinspection ALL
+	defer os.Chdir(wd)
+
+	test(dir)
+}
+
+func newRemoteWatcher(listen string, contents bool) *Watcher {
+	options := NewWatcherOptions(BackendRemote, time.Second, false, listen, contents, false)
+
+	return NewWatcher([]string{"."}, []string{"tmp"}, []string{"*.go"}, DefaultIgnoredFiles, options, NewStdoutLog(InfoLevel))
+}
+
+func TestAgentSendsChangesToRemoteWatcher(t *testing.T) {
+	inDir(t, func(dir string) {
+		if err := os.Mkdir("project", 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		receiver := newRemoteWatcher("unix://"+filepath.Join(dir, "runner.sock"), false)
+		events := make(chan fsnotify.Event, 1)
+		receiver.AddListener(func(event fsnotify.Event) {
+			events <- event
+		})
+		if err := receiver.Start(); err != nil {
+			t.Fatal(err)
+		}
+		//noinspection ALL
+		defer receiver.Stop()
+
+		if err := os.Chdir("project"); err != nil {
+			t.Fatal(err)
+		}
+		options := NewWatcherOptions(BackendFsnotify, time.Second, false, "", false, false)
+		watcher := NewWatcher([]string{"."}, nil, []string{"*.go"}, nil, options, NewStdoutLog(InfoLevel))
+		agent, err := NewAgent("unix://"+filepath.Join(dir, "runner.sock"), watcher, false, NewStdoutLog(InfoLevel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := agent.Start(); err != nil {
+			t.Fatal(err)
+		}
+		//noinspection ALL
+		defer agent.Stop()
+
+		if err := ioutil.WriteFile("main.go", []byte("package main\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case event := <-events:
+			if event.Name != "main.go" {
+				t.Errorf("expected change of main.go, got %s", event.Name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("change wasn't received")
+		}
+	})
+}
+
+func TestRemoteContents(t *testing.T) {
+	inDir(t, func(dir string) {
+		outside, err := ioutil.TempDir("", "runner-outside")
+		if err != nil {
+			t.Fatal(err)
+		}
+		//noinspection ALL
+		defer os.RemoveAll(outside)
+		if err := os.Symlink(outside, "link"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(outside, "target.go"), "linked.go"); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			path     string
+			contents bool
+			written  bool
+		}{
+			{"main.go", true, true},
+			{"pkg/main.go", true, true},
+			{"main.go", false, false},
+			{"notes.txt", true, false},
+			{"main.go~", true, false},
+			{"tmp/main.go", true, false},
+			{".git/main.go", true, false},
+			{"link/main.go", true, false},
+			{"linked.go", true, false},
+			{"../main.go", true, false},
+		}
+
+		for _, test := range tests {
+			watcher := newRemoteWatcher("", test.contents)
+			change := RemoteChange{Op: RemoteWrite, Path: test.path, HasContent: true, Content: []byte("package main\n")}
+
+			err := watcher.applyRemoteChange(change)
+			if test.written && err != nil {
+				t.Errorf("%s: unexpected error: %s", test.path, err)
+			}
+			if !test.written && err == nil {
+				t.Errorf("%s: expected the change to be rejected", test.path)
+			}
+
+			_, statErr := os.Stat(filepath.FromSlash(test.path))
+			if test.written && statErr != nil {
+				t.Errorf("%s: file wasn't written", test.path)
+			}
+			if !test.written && statErr == nil {
+				t.Errorf("%s: file was written", test.path)
+			}
+			if test.written {
+				_ = os.Remove(filepath.FromSlash(test.path))
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(outside, "main.go")); err == nil {
+			t.Error("file was written through a symlink")
+		}
+		if _, err := os.Stat(filepath.Join(outside, "target.go")); err == nil {
+			t.Error("file was written through a symlink")
+		}
+	})
+}
+
+func TestRemoteContentsMode(t *testing.T) {
+	inDir(t, func(dir string) {
+		watcher := newRemoteWatcher("", true)
+		change := RemoteChange{Op: RemoteCreate, Path: "main.go", HasContent: true, Mode: os.ModeSetuid | os.ModeSetgid | 0755}
+		if err := watcher.applyRemoteChange(change); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat("main.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 {
+			t.Errorf("special mode bits were applied: %s", info.Mode())
+		}
+	})
+}
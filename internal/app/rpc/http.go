@@ -0,0 +1,212 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kolah/runner/internal/app"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpShutdownTimeout limits how long stopping the server waits for requests in progress
+const httpShutdownTimeout = 5 * time.Second
+
+type httpResponse struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// argumentsFunc extracts command arguments from the request
+type argumentsFunc func(r *http.Request) []string
+
+// HTTPServer exposes control commands over HTTP and streams runner events with Server-Sent Events.
+type HTTPServer struct {
+	host     string
+	port     int
+	commands map[string]Handler
+	events   *app.EventBus
+	server   *http.Server
+	closing  chan struct{}
+}
+
+// NewHTTPServer creates a server listening on host and port, all interfaces when host is empty.
+func NewHTTPServer(host string, port int, commands map[string]Handler, events *app.EventBus) *HTTPServer {
+	s := &HTTPServer{
+		host:     host,
+		port:     port,
+		commands: commands,
+		events:   events,
+		closing:  make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.command(http.MethodGet, Status, noArguments))
+	mux.HandleFunc("/build", s.command(http.MethodPost, Build, noArguments))
+	mux.HandleFunc("/restart", s.command(http.MethodPost, Restart, noArguments))
+	mux.HandleFunc("/pause", s.command(http.MethodPost, Pause, noArguments))
+	mux.HandleFunc("/resume", s.command(http.MethodPost, Resume, resumeArguments))
+	mux.HandleFunc("/mode/", s.command(http.MethodPost, SetMode, modeArguments))
+	mux.HandleFunc("/build/errors", s.command(http.MethodGet, BuildErrors, noArguments))
+	mux.HandleFunc("/profile/", s.command(http.MethodPost, Profile, profileArguments))
+	mux.HandleFunc("/events", s.streamEvents)
+
+	s.server = &http.Server{Handler: mux}
+
+	return s
+}
+
+func (s *HTTPServer) Start() error {
+	socket, err := net.Listen("tcp", net.JoinHostPort(s.host, strconv.Itoa(s.port)))
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.server.Serve(socket); err != nil && err != http.ErrServerClosed {
+			fmt.Println("HTTP server error:", err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// Stop closes event streams and waits for requests in progress to finish.
+func (s *HTTPServer) Stop() error {
+	close(s.closing)
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	return s.server.Shutdown(ctx)
+}
+
+func (s *HTTPServer) command(method, name string, arguments argumentsFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, httpResponse{Status: ServerErr, Message: "Method not allowed"})
+			return
+		}
+
+		// web pages can send simple cross-origin requests, only pages served from this machine may change the state
+		if method != http.MethodGet && !localOrigin(r.Header.Get("Origin")) {
+			writeJSON(w, http.StatusForbidden, httpResponse{Status: ServerErr, Message: "Origin not allowed"})
+			return
+		}
+
+		handler, ok := s.commands[name]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, httpResponse{Status: ServerErr, Message: "Unknown command " + name})
+			return
+		}
+
+		response, err := handler(arguments(r))
+		if err != nil {
+			code := http.StatusInternalServerError
+			if _, ok := err.(ArgumentError); ok {
+				code = http.StatusBadRequest
+			}
+			writeJSON(w, code, httpResponse{Status: ServerErr, Message: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, httpResponse{Status: ServerOK, Message: response.Message, Data: response.Data})
+	}
+}
+
+func (s *HTTPServer) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, httpResponse{Status: ServerErr, Message: "Method not allowed"})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, httpResponse{Status: ServerErr, Message: "Streaming not supported"})
+		return
+	}
+
+	events, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// localOrigin tells whether the request comes from a page served from localhost, requests without Origin
+// are not sent by browsers
+func localOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	switch host := u.Hostname(); host {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, response httpResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	//noinspection ALL
+	json.NewEncoder(w).Encode(response)
+}
+
+func noArguments(r *http.Request) []string {
+	return nil
+}
+
+// modeArguments takes the mode from /mode/{debug|rebuild} path
+func modeArguments(r *http.Request) []string {
+	return []string{strings.ToUpper(strings.TrimPrefix(r.URL.Path, "/mode/"))}
+}
+
+// profileArguments takes the profile name from /profile/{name} path
+func profileArguments(r *http.Request) []string {
+	return []string{strings.TrimPrefix(r.URL.Path, "/profile/")}
+}
+
+// resumeArguments takes the resume action from ?discard=true query
+func resumeArguments(r *http.Request) []string {
+	if discard := r.URL.Query().Get("discard"); discard == "1" || discard == "true" {
+		return []string{ResumeDrop}
+	}
+
+	return []string{ResumeApply}
+}
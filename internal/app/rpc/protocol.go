@@ -6,6 +6,19 @@ const (
 )
 
 const (
-	SetMode = "SETMODE"
-	Stop    = "STOP"
+	SetMode     = "SETMODE"
+	Stop        = "STOP"
+	Build       = "BUILD"
+	Restart     = "RESTART"
+	Pause       = "PAUSE"
+	Resume      = "RESUME"
+	Status      = "STATUS"
+	BuildErrors = "BUILDERRORS"
+	Profile     = "PROFILE"
+)
+
+// arguments of the RESUME command
+const (
+	ResumeApply = "APPLY"
+	ResumeDrop  = "DROP"
 )
@@ -1,56 +1,207 @@
 package rpc
 
 import (
+	"errors"
 	"fmt"
 	"github.com/kolah/runner/internal/app"
 	"github.com/kolah/runner/internal/pkg/simplerpc"
 	"net"
-	"os"
+	"strings"
+	"time"
 )
 
-func StopHandler() simplerpc.ServerHandlerFunc {
+// Response is the result of a command, it's shared by the TCP and HTTP servers.
+type Response struct {
+	// Message is a single line summary of the result
+	Message string
+	// Data holds structured details of the result, exposed by the HTTP server
+	Data interface{}
+}
+
+// Handler executes a command with its arguments.
+type Handler func(args []string) (Response, error)
+
+// ArgumentError is returned by handlers called with invalid arguments.
+type ArgumentError struct {
+	message string
+}
+
+func newArgumentError(format string, args ...interface{}) error {
+	return ArgumentError{message: fmt.Sprintf(format, args...)}
+}
+
+func (e ArgumentError) Error() string {
+	return e.message
+}
+
+// Profiles switches configuration profiles of the running instance.
+type Profiles interface {
+	// Names returns names of profiles Switch accepts
+	Names() []string
+	// Switch applies the profile, rebuilding and restarting the application when needed
+	Switch(name string) error
+}
+
+// Commands returns handlers of all control commands, keyed by command name.
+func Commands(runner *app.Runner, shutdown *app.Shutdown, shutdownTimeout time.Duration, profiles Profiles) map[string]Handler {
+	return map[string]Handler{
+		Stop:        StopHandler(shutdown, shutdownTimeout),
+		SetMode:     SetModeHandler(runner),
+		Build:       BuildHandler(runner),
+		Restart:     RestartHandler(runner),
+		Pause:       PauseHandler(runner),
+		Resume:      ResumeHandler(runner),
+		Status:      StatusHandler(runner),
+		BuildErrors: BuildErrorsHandler(runner),
+		Profile:     ProfileHandler(profiles),
+	}
+}
+
+// TCPHandler adapts the handler to the line based TCP protocol.
+func TCPHandler(handler Handler) simplerpc.ServerHandlerFunc {
 	return func(c net.Conn, args []string) {
-		_, err := fmt.Fprintln(c, ServerOK)
+		response, err := handler(args)
 		if err != nil {
-			fmt.Println("Error returning response")
 			//noinspection ALL
-			c.Close()
+			fmt.Fprintln(c, ServerErr, err.Error())
 			return
 		}
+
+		if _, err := fmt.Fprintln(c, ServerOK, response.Message); err != nil {
+			fmt.Println("Error returning response")
+		}
+	}
+}
+
+// StopHandler requests shutdown and responds once it has completed, or with an error after timeout.
+func StopHandler(shutdown *app.Shutdown, timeout time.Duration) Handler {
+	return func(args []string) (Response, error) {
 		fmt.Println("Received STOP command")
-		os.Exit(0)
+		shutdown.Request()
+
+		if err := shutdown.Wait(timeout); err != nil {
+			return Response{}, err
+		}
+
+		return Response{Message: "Stopped"}, nil
 	}
 }
 
-func SetModeHandler(runner *app.Runner) simplerpc.ServerHandlerFunc {
-	return func(c net.Conn, args []string) {
+func SetModeHandler(runner *app.Runner) Handler {
+	return func(args []string) (Response, error) {
 		if len(args) != 1 {
-			_, err := fmt.Fprintln(c, ServerErr, "Invalid number of arguments")
-			if err != nil {
-				fmt.Println("Error returning response")
-				return
-			}
-			fmt.Println("Invalid number of arguments")
-
-			return
+			return Response{}, newArgumentError("Invalid number of arguments")
 		}
 
 		mode := app.RunnerMode(args[0])
-		if mode == app.ModeDebug || mode == app.ModeRebuild {
-			if err := runner.Build(); err != nil {
-				//noinspection ALL
-				fmt.Fprintln(c, ServerErr, "Build error")
-				//noinspection ALL
-				return
+		if mode != app.ModeDebug && mode != app.ModeRebuild {
+			return Response{}, newArgumentError("Unknown mode %s", mode)
+		}
+
+		if err := runner.SwitchMode(mode); err != nil {
+			return Response{}, errors.New("Build error")
+		}
+
+		return Response{Message: fmt.Sprint("Switched mode to ", mode)}, nil
+	}
+}
+
+func BuildHandler(runner *app.Runner) Handler {
+	return func(args []string) (Response, error) {
+		if err := runner.Rebuild(); err != nil {
+			return Response{}, errors.New("Build error")
+		}
+
+		return Response{Message: "Rebuilt"}, nil
+	}
+}
+
+func RestartHandler(runner *app.Runner) Handler {
+	return func(args []string) (Response, error) {
+		runner.Restart()
+
+		return Response{Message: "Restarted"}, nil
+	}
+}
+
+func PauseHandler(runner *app.Runner) Handler {
+	return func(args []string) (Response, error) {
+		runner.Pause()
+
+		return Response{Message: "Paused"}, nil
+	}
+}
+
+func ResumeHandler(runner *app.Runner) Handler {
+	return func(args []string) (Response, error) {
+		action := ResumeApply
+		if len(args) > 0 {
+			action = args[0]
+		}
+
+		switch action {
+		case ResumeApply:
+			runner.Resume(true)
+		case ResumeDrop:
+			runner.Resume(false)
+		default:
+			return Response{}, newArgumentError("Unknown resume action %s", action)
+		}
+
+		return Response{Message: "Resumed"}, nil
+	}
+}
+
+func StatusHandler(runner *app.Runner) Handler {
+	return func(args []string) (Response, error) {
+		status := runner.Status()
+
+		return Response{
+			Message: fmt.Sprintf(
+				"mode=%s paused=%t pending=%t running=%t build_failed=%t changed=%d",
+				status.Mode, status.Paused, status.Pending, status.Running, status.BuildFailed, len(status.Changes),
+			),
+			Data: status,
+		}, nil
+	}
+}
+
+// BuildErrorsHandler responds with the output of the last failed build,
+// the output is only included in the data as it spans multiple lines.
+func BuildErrorsHandler(runner *app.Runner) Handler {
+	return func(args []string) (Response, error) {
+		output := runner.LastBuildError()
+		if output == "" {
+			return Response{Message: "Last build succeeded", Data: ""}, nil
+		}
+
+		return Response{Message: "Last build failed", Data: output}, nil
+	}
+}
+
+// ProfileHandler switches to the profile, profile "none" switches back to the configuration without profile.
+func ProfileHandler(profiles Profiles) Handler {
+	return func(args []string) (Response, error) {
+		if len(args) != 1 {
+			return Response{}, newArgumentError("Invalid number of arguments")
+		}
+
+		name := strings.ToLower(args[0])
+		known := false
+		for _, profile := range profiles.Names() {
+			known = known || profile == name
+		}
+		if !known {
+			return Response{}, newArgumentError("Unknown profile %s, defined profiles: %s", name, strings.Join(profiles.Names(), ", "))
+		}
+
+		if err := profiles.Switch(name); err != nil {
+			if _, ok := err.(app.BuildErr); ok {
+				return Response{}, errors.New("Build error")
 			}
-			runner.SetMode(mode)
-			//noinspection ALL
-			fmt.Fprintln(c, ServerOK, "Switched mode to", mode)
-			return
-		} else {
-			//noinspection ALL
-			fmt.Fprintln(c, ServerErr, "Unknown mode", mode)
+			return Response{}, err
 		}
 
+		return Response{Message: fmt.Sprint("Switched profile to ", name)}, nil
 	}
 }
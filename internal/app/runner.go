@@ -2,8 +2,10 @@ package app
 
 import (
 	"github.com/fsnotify/fsnotify"
+	"reflect"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,39 +19,84 @@ const (
 type Runner struct {
 	sync.Mutex
 	worker       *Worker
+	debugger     *Worker
+	debugMonitor chan bool
 	builder      *Builder
 	watcher      *Watcher
 	mode         RunnerMode
+	paused       bool
+	queued       *ChangeSet
+	lastChanges  atomic.Value
 	options      RunnerOpts
 	loopIndex    int
-	events       chan interface{}
-	eventsBuffer []fsnotify.Event
+	changes      *ChangeSet
+	changed      chan struct{}
+	requested    *ChangeSet
+	wake         chan struct{}
 	quit         chan bool
+	stopped      chan bool
+	bus          *EventBus
 	logger       Logger
 	appLogger    *RunnerOutLog
 }
 
+// Status describes the current state of the runner.
+type Status struct {
+	Mode        RunnerMode `json:"mode"`
+	Paused      bool       `json:"paused"`
+	Pending     bool       `json:"pending"`
+	Running     bool       `json:"running"`
+	BuildFailed bool       `json:"build_failed"`
+	// Changes are the files that triggered the last build
+	Changes []FileChange `json:"changes,omitempty"`
+	// PendingChanges are collected while paused or queued while debugging
+	PendingChanges []FileChange `json:"pending_changes,omitempty"`
+}
+
 type RunnerOpts struct {
-	buildDelay       time.Duration
-	runCommand       string
-	debugCommand     string
-	buildBeforeDebug bool
+	buildDelay time.Duration
+	maxWait    time.Duration
+	runCommand Command
+	runDir     string
+	debug      DebugOpts
+	stdin      StdinMode
+	stdinFile  string
+	vars       CommandVars
 }
 
-func NewRunnerOptions(buildDelay time.Duration, runCommand string, debugCommand string, buildBeforeDebug bool) RunnerOpts {
-	return RunnerOpts{buildDelay: buildDelay, runCommand: runCommand, debugCommand: debugCommand, buildBeforeDebug: buildBeforeDebug}
+// NewRunnerOptions creates runner options, a build is triggered once files haven't changed for buildDelay,
+// but no later than maxWait after the first change. The maxWait limit is disabled when 0.
+// Commands are templates evaluated with vars every time they are started, they run in runDir,
+// current directory when empty.
+func NewRunnerOptions(buildDelay, maxWait time.Duration, runCommand Command, runDir string, debug DebugOpts, stdin StdinMode, stdinFile string, vars CommandVars) RunnerOpts {
+	return RunnerOpts{
+		buildDelay: buildDelay,
+		maxWait:    maxWait,
+		runCommand: runCommand,
+		runDir:     runDir,
+		debug:      debug,
+		stdin:      stdin,
+		stdinFile:  stdinFile,
+		vars:       vars,
+	}
 }
 
 func NewRunner(watcher *Watcher, builder *Builder, options RunnerOpts, logger Logger, appLogger *RunnerOutLog) *Runner {
 	return &Runner{
-		builder:      builder,
-		mode:         ModeRebuild,
-		watcher:      watcher,
-		options:      options,
-		eventsBuffer: make([]fsnotify.Event, 0),
-		quit:         make(chan bool, 1),
-		logger:       logger,
-		appLogger:    appLogger,
+		builder:   builder,
+		mode:      ModeRebuild,
+		watcher:   watcher,
+		options:   options,
+		changes:   NewChangeSet(),
+		queued:    NewChangeSet(),
+		changed:   make(chan struct{}, 1),
+		requested: NewChangeSet(),
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan bool),
+		stopped:   make(chan bool),
+		bus:       NewEventBus(),
+		logger:    logger,
+		appLogger: appLogger,
 	}
 }
 
@@ -69,58 +116,204 @@ func (r *Runner) Start() error {
 
 	// start worker only on successful initial build
 	if !buildErr {
-		r.worker = NewWorker(r.options.runCommand, r.logger, r.appLogger)
-		if err := r.worker.Run(); err != nil {
+		r.Lock()
+		args, err := r.argv(r.options.runCommand)
+		if err == nil {
+			r.worker = NewWorker(args, r.options.runDir, r.options.stdin, r.options.stdinFile, r.logger, r.appLogger)
+			err = r.worker.Run()
+			r.bus.Publish(NewEvent(EventStarted, r.worker.command))
+		}
+		r.Unlock()
+		if err != nil {
 			return err
 		}
 	}
 
 	r.watcher.AddListener(func(event fsnotify.Event) {
 		r.Lock()
-		defer r.Unlock()
+		r.changes.Add(event)
+		r.Unlock()
 
-		if r.options.buildDelay == 0 {
-			r.logger.Debug("Watched files changed, triggering event\n")
-			r.events <- struct{}{}
-			return
-		}
-
-		r.eventsBuffer = append(r.eventsBuffer, event)
-		if len(r.eventsBuffer) == 1 {
-			time.AfterFunc(r.options.buildDelay, func() {
-				r.Lock()
-				defer r.Unlock()
-				r.logger.Debug("Watched files changed, triggering event after delay\n")
-
-				r.events <- struct{}{}
-
-				// reset events buffer
-				r.eventsBuffer = make([]fsnotify.Event, 0)
-			})
+		select {
+		case r.changed <- struct{}{}:
+		default:
 		}
 	})
 
-	r.events = make(chan interface{})
-
+	go r.debounce()
 	go r.mainLoop()
 
 	return nil
 }
 
+// Stop terminates the main loop, then stops the worker and the watcher.
 func (r *Runner) Stop() error {
-	r.quit <- true
+	close(r.quit)
+	<-r.stopped
+
+	r.Lock()
+	r.detach()
+	r.stopDebugMonitor()
 	if r.worker != nil {
 		r.worker.Stop()
+		r.worker = nil
 	}
+	r.Unlock()
 
 	return r.watcher.Stop()
 }
 
+// debounce triggers a build once files haven't changed for the build delay,
+// or once the max wait has passed since the first change
+func (r *Runner) debounce() {
+	var delay, maxWait *time.Timer
+	var delayC, maxWaitC <-chan time.Time
+
+	for {
+		select {
+		case <-r.changed:
+			r.Lock()
+			buildDelay, maxWaitDelay := r.options.buildDelay, r.options.maxWait
+			r.Unlock()
+
+			if buildDelay == 0 {
+				r.logger.Debug("Watched files changed, triggering event\n")
+				r.fire()
+				continue
+			}
+
+			if delay != nil {
+				delay.Stop()
+			}
+			delay = time.NewTimer(buildDelay)
+			delayC = delay.C
+
+			if maxWait == nil && maxWaitDelay > 0 {
+				maxWait = time.NewTimer(maxWaitDelay)
+				maxWaitC = maxWait.C
+			}
+			continue
+		case <-delayC:
+			r.logger.Debug("Watched files changed, triggering event after delay\n")
+		case <-maxWaitC:
+			r.logger.Debug("Watched files keep changing, triggering event after max wait\n")
+		case <-r.quit:
+			return
+		}
+
+		delay.Stop()
+		if maxWait != nil {
+			maxWait.Stop()
+		}
+		delay, maxWait, delayC, maxWaitC = nil, nil, nil, nil
+
+		r.fire()
+	}
+}
+
+// fire passes changes collected since the last trigger to the main loop
+func (r *Runner) fire() {
+	r.Lock()
+	defer r.Unlock()
+
+	if changes := r.takeChanges().settle(); changes.Len() > 0 {
+		r.trigger(changes)
+	}
+}
+
+// trigger requests a build of the changes, changes requested while building are merged
+// into a single follow-up build. The caller must hold the lock.
+func (r *Runner) trigger(changes *ChangeSet) {
+	r.requested.Merge(changes)
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takeRequested returns changes requested since the last build, the caller must hold the lock
+func (r *Runner) takeRequested() *ChangeSet {
+	requested := r.requested
+	r.requested = NewChangeSet()
+
+	return requested
+}
+
+// takeChanges returns changes collected since the last trigger, the caller must hold the lock
+func (r *Runner) takeChanges() *ChangeSet {
+	changes := r.changes
+	r.changes = NewChangeSet()
+
+	return changes
+}
+
+// takeQueued returns changes collected while paused or queued while debugging, the caller must hold the lock
+func (r *Runner) takeQueued() *ChangeSet {
+	queued := r.queued
+	r.queued = NewChangeSet()
+
+	return queued
+}
+
 func (r *Runner) Build() error {
-	return r.builder.Build()
+	return r.build(NewChangeSet(), r.Mode())
+}
+
+// build runs the build, changed files are logged, passed to the build command and included in events
+func (r *Runner) build(changes *ChangeSet, mode RunnerMode) error {
+	if changes.Len() > 0 {
+		r.logger.Infof("Rebuilding: %s\n", changes)
+		r.lastChanges.Store(changes.Changes())
+	}
+
+	r.bus.Publish(newBuildEvent(EventBuildStarted, "", changes))
+
+	err := r.builder.Build(changes.Files(), mode)
+	if err != nil {
+		r.bus.Publish(newBuildEvent(EventBuildFailed, err.Error(), changes))
+	} else {
+		r.bus.Publish(newBuildEvent(EventBuildFinished, "", changes))
+	}
+
+	return err
+}
+
+// Events returns the bus runner publishes state changes to.
+func (r *Runner) Events() *EventBus {
+	return r.bus
+}
+
+// Status returns the current state of the runner.
+func (r *Runner) Status() Status {
+	// read before taking the lock, listeners and key commands must not wait for the status
+	buildFailed := r.builder.LastError() != ""
+
+	r.Lock()
+	defer r.Unlock()
+
+	changes, _ := r.lastChanges.Load().([]FileChange)
+
+	return Status{
+		Mode:           r.mode,
+		Paused:         r.paused,
+		Pending:        r.queued.Len() > 0,
+		Running:        r.worker != nil && r.worker.Running(),
+		BuildFailed:    buildFailed,
+		Changes:        changes,
+		PendingChanges: r.queued.Changes(),
+	}
+}
+
+// LastBuildError returns the output of the last failed build.
+func (r *Runner) LastBuildError() string {
+	return r.builder.LastError()
 }
 
 func (r *Runner) Mode() RunnerMode {
+	r.Lock()
+	defer r.Unlock()
+
 	return r.mode
 }
 
@@ -128,53 +321,264 @@ func (r *Runner) SetMode(mode RunnerMode) {
 	r.Lock()
 	defer r.Unlock()
 
-	if r.worker != nil {
-		r.worker.Stop()
-	}
-
 	r.logger.Infof("Switching mode to %s\n", mode)
-	command := r.options.runCommand
-	if mode == ModeDebug {
-		command = r.options.debugCommand
-		if r.options.buildBeforeDebug {
-			err := r.Build()
-			if err != nil {
+	r.mode = mode
+	r.bus.Publish(NewEvent(EventModeChanged, string(mode)))
+
+	// the application keeps running, only the debugger is attached or detached
+	if r.options.debug.strategy == DebugAttach {
+		if mode == ModeDebug && r.options.debug.buildBeforeDebug {
+			// the running binary may be built without debug flags, restart attaches to the new one
+			if err := r.build(NewChangeSet(), mode); err != nil {
 				r.logger.Infof("Build error: %s\n", err)
 				return
 			}
+			r.restart()
+			return
+		}
+		if mode == ModeDebug {
+			r.attach()
+			return
+		}
+
+		r.detach()
+		if queued := r.takeQueued(); queued.Len() > 0 {
+			r.logger.Info("Applying changes queued while debugging\n")
+			if err := r.build(queued, mode); err == nil {
+				r.restart()
+			}
+		}
+		return
+	}
+
+	// changes queued while debugging are applied by the build preceding the switch
+	if mode != ModeDebug {
+		r.queued = NewChangeSet()
+	}
+
+	r.stopDebugMonitor()
+	if r.worker != nil {
+		r.worker.Stop()
+		r.worker = nil
+	}
+
+	if mode == ModeDebug && r.options.debug.buildBeforeDebug {
+		err := r.build(NewChangeSet(), mode)
+		if err != nil {
+			r.logger.Infof("Build error: %s\n", err)
+			return
+		}
+	}
+
+	r.restart()
+}
+
+// SwitchMode builds the application and switches to the mode on success.
+// When the debugger is attached to the running application, the mode is switched without building.
+func (r *Runner) SwitchMode(mode RunnerMode) error {
+	if r.options.debug.strategy != DebugAttach {
+		changes := NewChangeSet()
+		if mode != ModeDebug {
+			r.Lock()
+			changes.Merge(r.queued)
+			r.Unlock()
 		}
+
+		if err := r.build(changes, mode); err != nil {
+			return err
+		}
+	}
+
+	r.SetMode(mode)
+
+	return nil
+}
+
+// Reconfigure replaces runner options. With rebuild the application is built and restarted, otherwise
+// it's restarted only when settings affecting how it runs in current mode have changed.
+func (r *Runner) Reconfigure(options RunnerOpts, rebuild bool) error {
+	r.Lock()
+	previous := r.options
+	r.options = options
+	changed := !reflect.DeepEqual(previous.runCommand, options.runCommand) ||
+		previous.runDir != options.runDir ||
+		previous.stdin != options.stdin ||
+		previous.stdinFile != options.stdinFile ||
+		!reflect.DeepEqual(previous.vars, options.vars) ||
+		(r.mode == ModeDebug && !reflect.DeepEqual(previous.debug, options.debug))
+	r.Unlock()
+
+	if rebuild {
+		return r.Rebuild()
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if changed && r.worker != nil {
+		r.logger.Info("Run settings changed, restarting\n")
+		r.restart()
+	}
+
+	return nil
+}
+
+// Rebuild builds the application and restarts the worker, even if nothing has changed.
+func (r *Runner) Rebuild() error {
+	r.logger.Info("Rebuild requested\n")
+	if err := r.Build(); err != nil {
+		return err
 	}
 
-	r.worker = NewWorker(command, r.logger, r.appLogger)
+	r.Restart()
+
+	return nil
+}
+
+// Restart restarts the worker in current mode without building.
+func (r *Runner) Restart() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.restart()
+}
+
+// Paused tells whether handling of file changes is paused.
+func (r *Runner) Paused() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.paused
+}
+
+// Pause stops reacting to file changes, changes are still collected while paused.
+func (r *Runner) Pause() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.logger.Info("Pausing, file changes will be collected until resumed\n")
+	r.paused = true
+	r.bus.Publish(NewEvent(EventPaused, ""))
+}
+
+// Resume resumes reacting to file changes. Changes collected while paused are either
+// applied with a rebuild or dropped.
+func (r *Runner) Resume(apply bool) {
+	r.Lock()
+	queued := r.takeQueued()
+	r.paused = false
+	r.Unlock()
+
+	r.bus.Publish(NewEvent(EventResumed, ""))
+
+	if queued.Len() == 0 {
+		r.logger.Info("Resuming, no changes collected while paused\n")
+		return
+	}
+
+	if !apply {
+		r.logger.Info("Resuming, dropping changes collected while paused\n")
+		return
+	}
+
+	r.logger.Info("Resuming, applying changes collected while paused\n")
+	r.Lock()
+	r.trigger(queued)
+	r.Unlock()
+}
+
+// restart stops the worker and starts the command of current mode, the caller must hold the lock
+func (r *Runner) restart() {
+	r.detach()
+	r.stopDebugMonitor()
+	if r.worker != nil {
+		r.worker.Stop()
+	}
+
+	debug := r.mode == ModeDebug
+	command := r.options.runCommand
+	if debug && r.options.debug.strategy == DebugExec {
+		command = r.options.debug.command
+	}
+
+	args, err := r.argv(command)
+	if err != nil {
+		r.worker = nil
+		r.logger.Infof("Failed to run \"%s\", %s\n", command, err.Error())
+		return
+	}
+
+	r.worker = NewWorker(args, r.options.runDir, r.options.stdin, r.options.stdinFile, r.logger, r.appLogger)
 	if err := r.worker.Run(); err != nil {
 		r.logger.Infof("Failed to run \"%s\", %s", command, err.Error())
+		return
 	}
+	r.bus.Publish(NewEvent(EventStarted, r.worker.command))
+
+	if debug && r.options.debug.strategy == DebugAttach {
+		r.attach()
+	} else if debug {
+		r.startDebugMonitor(r.worker)
+	}
+}
+
+// argv evaluates the command for current mode and the last changes, the caller must hold the lock
+func (r *Runner) argv(command Command) ([]string, error) {
+	changes, _ := r.lastChanges.Load().([]FileChange)
+	files := make([]string, 0, len(changes))
+	for _, change := range changes {
+		files = append(files, change.File)
+	}
+
+	return command.Argv(r.options.vars.with(r.mode, files))
+}
+
+// collect keeps changes pending when paused and tells whether they were collected
+func (r *Runner) collect(changes *ChangeSet) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.paused {
+		r.queued.Merge(changes)
+	}
+
+	return r.paused
 }
 
 func (r *Runner) mainLoop() {
+	defer close(r.stopped)
+
 	for {
 		r.loopIndex++
 
 		r.logger.Infof("Waiting (loop %d)...\n", r.loopIndex)
-		<-r.events
+		select {
+		case <-r.wake:
+		case <-r.quit:
+			return
+		}
+
+		r.Lock()
+		changes := r.takeRequested()
+		r.Unlock()
+		if changes.Len() == 0 {
+			continue
+		}
 
 		r.logger.Debugf("Rebuild triggered! (%d Go routines)\n", runtime.NumGoroutine())
 
-		if r.Mode() == ModeDebug {
-			r.logger.Debug("ignoring code changes while debugging\n")
+		if r.collect(changes) {
+			r.logger.Info("Paused, collecting changes until resumed\n")
 			continue
 		}
 
-		err := r.Build()
-		if err == nil {
-			r.SetMode(r.Mode())
+		mode := r.Mode()
+		if mode == ModeDebug && !r.handleDebugChange(changes) {
+			continue
 		}
 
-		select {
-		case <-r.quit:
-			return
-		default:
-			continue
+		if err := r.build(changes, mode); err == nil {
+			r.Restart()
 		}
 	}
 }
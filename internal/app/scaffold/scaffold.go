@@ -0,0 +1,298 @@
+// Package scaffold detects the stack of a project and generates a runner configuration for it.
+package scaffold
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/spf13/viper"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Project describes what was detected in the project directory.
+type Project struct {
+	// Module is the module path from go.mod, empty without go.mod
+	Module string
+	// Mains are directories of main packages, relative to the project root
+	Mains  []string
+	Templ  bool
+	Sqlc   bool
+	Buf    bool
+	Node   bool
+	Docker bool
+	// SqlcOut and BufOut are directories code is generated into, relative to the project root
+	SqlcOut []string
+	BufOut  []string
+}
+
+// Detect inspects the project directory.
+func Detect(root string) (Project, error) {
+	p := Project{
+		Sqlc:   exists(root, "sqlc.yaml") || exists(root, "sqlc.yml") || exists(root, "sqlc.json"),
+		Buf:    exists(root, "buf.yaml") || exists(root, "buf.gen.yaml"),
+		Node:   exists(root, "package.json"),
+		Docker: exists(root, "Dockerfile") || exists(root, "docker-compose.yml") || exists(root, "compose.yaml"),
+	}
+
+	for _, name := range []string{"sqlc.yaml", "sqlc.yml", "sqlc.json"} {
+		if exists(root, name) {
+			p.SqlcOut = sqlcOut(filepath.Join(root, name))
+			break
+		}
+	}
+	if exists(root, "buf.gen.yaml") {
+		p.BufOut = bufOut(filepath.Join(root, "buf.gen.yaml"))
+	}
+
+	if contents, err := ioutil.ReadFile(filepath.Join(root, "go.mod")); err == nil {
+		p.Module = modulePath(contents)
+		p.Templ = bytes.Contains(contents, []byte("github.com/a-h/templ"))
+	}
+
+	if isMain(root) {
+		p.Mains = append(p.Mains, ".")
+	}
+
+	err := filepath.Walk(filepath.Join(root, "cmd"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// no cmd directory
+			return nil
+		}
+		if info.IsDir() && isMain(path) {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			p.Mains = append(p.Mains, "./"+filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return p, err
+	}
+	sort.Strings(p.Mains)
+
+	return p, nil
+}
+
+// Config options derived from the detected project
+type Config struct {
+	BuildCommand  string
+	WatchPatterns []string
+	IgnoredDirs   []string
+	IgnoredFiles  []string
+	Notes         []string
+}
+
+// NewConfig derives the configuration for the main package.
+func NewConfig(p Project, main string, defaultIgnoredFiles []string) Config {
+	c := Config{
+		WatchPatterns: []string{"*.go", "go.mod", "go.sum"},
+		IgnoredDirs:   []string{"tmp", "vendor"},
+		IgnoredFiles:  append([]string(nil), defaultIgnoredFiles...),
+	}
+
+	var generate []string
+	if p.Templ {
+		generate = append(generate, "templ generate")
+		c.WatchPatterns = append(c.WatchPatterns, "*.templ")
+		// generated by the build, watching them would trigger another build
+		c.IgnoredFiles = append(c.IgnoredFiles, "*_templ.go")
+	}
+	// generated code is written into watched directories, unless they are ignored every build triggers another one
+	if sqlcOut := generatedDirs(p.SqlcOut); p.Sqlc && len(sqlcOut) > 0 {
+		generate = append(generate, "sqlc generate")
+		c.WatchPatterns = append(c.WatchPatterns, "*.sql")
+		c.IgnoredDirs = append(c.IgnoredDirs, sqlcOut...)
+	} else if p.Sqlc {
+		c.Notes = append(c.Notes, "sqlc output directories not found, run \"sqlc generate\" before building "+
+			"or add it to build.command along with the output directories to ignored_directories")
+	}
+	if p.Buf {
+		generate = append(generate, "buf generate")
+		c.WatchPatterns = append(c.WatchPatterns, "*.proto")
+		c.IgnoredDirs = append(c.IgnoredDirs, generatedDirs(p.BufOut)...)
+		// protoc-gen-go, grpc, grpc-gateway, validate and connect output, for code generated next to the protos
+		c.IgnoredFiles = append(c.IgnoredFiles, "*.pb.go", "*.pb.gw.go", "*.pb.validate.go", "*.connect.go")
+	}
+	if p.Node {
+		c.IgnoredDirs = append(c.IgnoredDirs, "node_modules")
+	}
+	if p.Docker {
+		c.Notes = append(c.Notes, "Docker on macOS and Windows doesn't deliver file system events, "+
+			"use watch.backend: poll or \"runner agent\" with watch.backend: remote")
+	}
+
+	build := "go build -gcflags='all=-N -l' -o {{.BinaryPath}} " + main
+	if len(generate) > 0 {
+		build = "sh -c " + strconv.Quote(strings.Join(append(generate, build), " && "))
+	}
+	c.BuildCommand = build
+
+	return c
+}
+
+var configTemplate = template.Must(template.New("runner.yaml").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(
+	`# Generated by "runner init"{{with .Module}} for {{.}}{{end}}, see runner.yaml.dist for all options
+{{- range .Notes}}
+# Note: {{.}}
+{{- end}}
+watch:
+    directories: # A list of directories to watch
+        - .
+    watch_patterns: # A list of file patterns to trigger rebuild
+{{- range .WatchPatterns}}
+        - {{quote .}}
+{{- end}}
+    ignored_directories: # A list of directories not to watch
+{{- range .IgnoredDirs}}
+        - {{quote .}}
+{{- end}}
+    ignored_files: # Files never triggering a build, even when matching watch_patterns
+{{- range .IgnoredFiles}}
+        - {{quote .}}
+{{- end}}
+build:
+    command: {{quote .BuildCommand}} # Command triggered to build the application
+    delay: 650ms # Build once files haven't changed for this long
+run:
+    command: "{{"{{.BinaryPath}}"}}" # Command running the built application
+    debug_port: 2345 # Port the debugger listens on
+`))
+
+// Render returns runner.yaml contents.
+func Render(p Project, c Config) ([]byte, error) {
+	var b bytes.Buffer
+	err := configTemplate.Execute(&b, struct {
+		Config
+		Module string
+	}{c, p.Module})
+
+	return b.Bytes(), err
+}
+
+// sqlcOut returns output directories of the sqlc config, version 1 packages and version 2 gen and codegen output
+func sqlcOut(file string) []string {
+	var config struct {
+		Packages []struct {
+			Path string
+		}
+		SQL []struct {
+			Gen map[string]struct {
+				Out string
+			}
+			Codegen []struct {
+				Out string
+			}
+		} `mapstructure:"sql"`
+	}
+	if err := readConfig(file, &config); err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, p := range config.Packages {
+		dirs = append(dirs, p.Path)
+	}
+	for _, sql := range config.SQL {
+		for _, gen := range sql.Gen {
+			dirs = append(dirs, gen.Out)
+		}
+		for _, gen := range sql.Codegen {
+			dirs = append(dirs, gen.Out)
+		}
+	}
+
+	return dirs
+}
+
+// bufOut returns output directories of plugins in buf.gen.yaml
+func bufOut(file string) []string {
+	var config struct {
+		Plugins []struct {
+			Out string
+		}
+	}
+	if err := readConfig(file, &config); err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, p := range config.Plugins {
+		dirs = append(dirs, p.Out)
+	}
+
+	return dirs
+}
+
+func readConfig(file string, config interface{}) error {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+
+	return v.Unmarshal(config)
+}
+
+// generatedDirs returns output directories which can be ignored, the project root and directories outside of it
+// are skipped
+func generatedDirs(out []string) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, dir := range out {
+		dir = filepath.ToSlash(filepath.Clean(dir))
+		if dir != "." && dir != ".." && !filepath.IsAbs(dir) && !strings.HasPrefix(dir, "../") && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+func exists(root, name string) bool {
+	_, err := os.Stat(filepath.Join(root, name))
+
+	return err == nil
+}
+
+func modulePath(goMod []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(goMod))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], "\"")
+		}
+	}
+
+	return ""
+}
+
+// isMain tells whether the directory contains a main package
+func isMain(dir string) bool {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return false
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly)
+		if err == nil && f.Name.Name == "main" {
+			return true
+		}
+	}
+
+	return false
+}
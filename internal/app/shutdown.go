@@ -0,0 +1,57 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrShutdownTimeout is returned by Shutdown.Wait when shutdown did not complete in time.
+var ErrShutdownTimeout = errors.New("shutdown timed out")
+
+// Shutdown coordinates an orderly shutdown that can be requested from several places
+// (signals, control commands) and lets the requesters wait for it to complete.
+type Shutdown struct {
+	requestOnce sync.Once
+	finishOnce  sync.Once
+	requested   chan struct{}
+	finished    chan struct{}
+	err         error
+}
+
+func NewShutdown() *Shutdown {
+	return &Shutdown{
+		requested: make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+}
+
+// Request asks for shutdown, it's safe to call it multiple times.
+func (s *Shutdown) Request() {
+	s.requestOnce.Do(func() {
+		close(s.requested)
+	})
+}
+
+// Requested returns a channel closed once shutdown was requested.
+func (s *Shutdown) Requested() <-chan struct{} {
+	return s.requested
+}
+
+// Finish marks shutdown as completed with the given error.
+func (s *Shutdown) Finish(err error) {
+	s.finishOnce.Do(func() {
+		s.err = err
+		close(s.finished)
+	})
+}
+
+// Wait blocks until shutdown is finished or the timeout passes.
+func (s *Shutdown) Wait(timeout time.Duration) error {
+	select {
+	case <-s.finished:
+		return s.err
+	case <-time.After(timeout):
+		return ErrShutdownTimeout
+	}
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"github.com/kballard/go-shellquote"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Files are paths of files, printed as shell quoted arguments, e.g. in go vet {{.ChangedFiles}}
+type Files []string
+
+func (f Files) String() string {
+	return shellquote.Join(f...)
+}
+
+// CommandVars are the values commands can refer to, e.g. go build -o {{.BinaryPath}} .
+type CommandVars struct {
+	TmpDir      string
+	BinaryPath  string
+	ProjectRoot string
+	// ChangedFiles are the files that triggered the build
+	ChangedFiles Files
+	// Mode is "rebuild" or "debug"
+	Mode      string
+	DebugPort int
+}
+
+func NewCommandVars(tmpDir, binaryPath, projectRoot string, debugPort int) CommandVars {
+	return CommandVars{
+		TmpDir:      tmpDir,
+		BinaryPath:  binaryPath,
+		ProjectRoot: projectRoot,
+		Mode:        strings.ToLower(string(ModeRebuild)),
+		DebugPort:   debugPort,
+	}
+}
+
+// with returns the values for a command run in the mode after the files have changed
+func (v CommandVars) with(mode RunnerMode, changedFiles []string) CommandVars {
+	v.Mode = strings.ToLower(string(mode))
+	v.ChangedFiles = changedFiles
+
+	return v
+}
+
+// quoted returns the values with paths shell quoted, so that they stay single arguments
+// when the rendered command line is split
+func (v CommandVars) quoted() CommandVars {
+	for _, path := range []*string{&v.TmpDir, &v.BinaryPath, &v.ProjectRoot} {
+		if *path != "" {
+			*path = shellquote.Join(*path)
+		}
+	}
+
+	return v
+}
+
+// changedFilesArg matches an argument consisting only of the changed files, it's expanded into an argument per file
+var changedFilesArg = regexp.MustCompile(`^{{-?\s*\.ChangedFiles\s*-?}}$`)
+
+// RenderCommand evaluates the command template, {{env "NAME"}} is replaced with the environment variable.
+func RenderCommand(command string, vars CommandVars) (string, error) {
+	if !strings.Contains(command, "{{") {
+		return command, nil
+	}
+
+	tmpl, err := template.New("command").Funcs(template.FuncMap{"env": os.Getenv}).Parse(command)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, vars); err != nil {
+		return "", err
+	}
+
+	return rendered.String(), nil
+}
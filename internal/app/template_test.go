@@ -0,0 +1,108 @@
+package app
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestArgvTemplates(t *testing.T) {
+	vars := NewCommandVars("/tmp/my project/tmp", "/tmp/my project/tmp/build", "/tmp/my project", 2345).
+		with(ModeDebug, []string{"main.go", "pkg/file name.go"})
+
+	tests := []struct {
+		name     string
+		command  Command
+		expected []string
+	}{
+		{
+			name:     "paths with spaces stay single arguments in a line",
+			command:  NewCommand("go build -o {{.BinaryPath}} {{.ProjectRoot}}/cmd"),
+			expected: []string{"go", "build", "-o", "/tmp/my project/tmp/build", "/tmp/my project/cmd"},
+		},
+		{
+			name:     "changed files are quoted in a line",
+			command:  NewCommand("go vet {{.ChangedFiles}}"),
+			expected: []string{"go", "vet", "main.go", "pkg/file name.go"},
+		},
+		{
+			name:     "paths are quoted for the shell",
+			command:  NewCommand("go build -o {{.BinaryPath}} . > {{.TmpDir}}/log").WithShell([]string{"sh", "-c"}),
+			expected: []string{"sh", "-c", "go build -o '/tmp/my project/tmp/build' . > '/tmp/my project/tmp'/log"},
+		},
+		{
+			name:     "arguments of a list are used as is",
+			command:  NewArgsCommand("{{.BinaryPath}}", "--root={{.ProjectRoot}}", "--mode={{.Mode}}", "--port={{.DebugPort}}"),
+			expected: []string{"/tmp/my project/tmp/build", "--root=/tmp/my project", "--mode=debug", "--port=2345"},
+		},
+		{
+			name:     "changed files argument is expanded in a list",
+			command:  NewArgsCommand("go", "vet", "{{.ChangedFiles}}"),
+			expected: []string{"go", "vet", "main.go", "pkg/file name.go"},
+		},
+		{
+			name:     "changed files argument with spaces in the action is expanded",
+			command:  NewArgsCommand("go", "vet", "{{ .ChangedFiles }}"),
+			expected: []string{"go", "vet", "main.go", "pkg/file name.go"},
+		},
+		{
+			name:     "changed files within an argument are not expanded",
+			command:  NewArgsCommand("echo", "files: {{.ChangedFiles}}"),
+			expected: []string{"echo", "files: main.go 'pkg/file name.go'"},
+		},
+		{
+			name:     "expanded list is quoted for the shell",
+			command:  NewArgsCommand("go", "vet", "{{.ChangedFiles}}").WithShell([]string{"sh", "-c"}),
+			expected: []string{"sh", "-c", "go vet main.go 'pkg/file name.go'"},
+		},
+	}
+
+	for _, test := range tests {
+		args, err := test.command.Argv(vars)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, args)
+		}
+	}
+}
+
+func TestArgvWithoutChangedFiles(t *testing.T) {
+	vars := NewCommandVars("tmp", "tmp/build", "/project", 2345)
+
+	args, err := NewArgsCommand("go", "vet", "{{.ChangedFiles}}").Argv(vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"go", "vet"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+
+	if _, err := NewArgsCommand("{{.ChangedFiles}}").Argv(vars); err != ErrEmptyCommand {
+		t.Errorf("expected empty command error, got %v", err)
+	}
+}
+
+func TestRenderCommand(t *testing.T) {
+	vars := NewCommandVars("tmp", "tmp/build", "/project", 2345)
+
+	if _, err := RenderCommand("go build -o {{.BinaryPth}}", vars); err == nil {
+		t.Error("expected an error for an unknown variable")
+	}
+
+	if err := os.Setenv("RUNNER_TEST_TAGS", "integration"); err != nil {
+		t.Fatal(err)
+	}
+	//noinspection ALL
+	defer os.Unsetenv("RUNNER_TEST_TAGS")
+
+	rendered, err := RenderCommand(`{{.BinaryPath}} --mode {{.Mode}} -tags {{env "RUNNER_TEST_TAGS"}}`, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "tmp/build --mode rebuild -tags integration"; rendered != expected {
+		t.Errorf("expected %q, got %q", expected, rendered)
+	}
+}
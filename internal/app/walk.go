@@ -0,0 +1,73 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// walkFunc is called for every file and directory visited by walkTree, real is the path
+// with symlinks resolved for directories. Returning filepath.SkipDir for a directory skips its contents.
+type walkFunc func(path string, real string, info os.FileInfo, err error) error
+
+// walkTree walks the tree like filepath.Walk, with follow symlinked directories are walked as well
+// and reported under the path they are linked at. Directories are visited once by real path, visited
+// ones are skipped, which deduplicates directories linked more than once and breaks symlink cycles.
+func walkTree(root string, follow bool, visited map[string]bool, fn walkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, root, nil, err)
+	}
+
+	err = walk(root, info, follow, visited, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+
+	return err
+}
+
+func walk(path string, info os.FileInfo, follow bool, visited map[string]bool, fn walkFunc) error {
+	if follow && info.Mode()&os.ModeSymlink != 0 {
+		// broken links and links to files are reported as they are
+		if target, err := os.Stat(path); err == nil && target.IsDir() {
+			info = target
+		}
+	}
+
+	if !info.IsDir() {
+		return fn(path, path, info, nil)
+	}
+
+	real := filepath.Clean(path)
+	if follow {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return fn(path, real, info, err)
+		}
+		real = resolved
+	}
+
+	if visited[real] {
+		return nil
+	}
+	visited[real] = true
+
+	if err := fn(path, real, info, nil); err != nil {
+		return err
+	}
+
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return fn(path, real, info, err)
+	}
+
+	for _, entry := range infos {
+		err := walk(filepath.Join(path, entry.Name()), entry, follow, visited, fn)
+		if err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+
+	return nil
+}
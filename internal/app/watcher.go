@@ -2,34 +2,128 @@ package app
 
 import (
 	"errors"
+	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"github.com/kolah/runner/internal/pkg/set"
+	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
+// WatchBackend defines how file changes are detected.
+type WatchBackend string
+
+const (
+	// BackendFsnotify receives events from the operating system
+	BackendFsnotify WatchBackend = "fsnotify"
+	// BackendPoll periodically scans watched directories
+	BackendPoll WatchBackend = "poll"
+	// BackendAuto uses fsnotify, falling back to polling when a probe write doesn't produce an event
+	BackendAuto WatchBackend = "auto"
+	// BackendRemote receives changes sent by the runner agent running on another machine
+	BackendRemote WatchBackend = "remote"
+)
+
+// maxWatchesFile holds the per user limit of inotify watches
+const maxWatchesFile = "/proc/sys/fs/inotify/max_user_watches"
+
+// probeTimeout is how long the auto backend waits for an event caused by the probe write
+const probeTimeout = 2 * time.Second
+
+// ParseWatchBackend takes a string and returns the watch backend constant.
+func ParseWatchBackend(backend string) (WatchBackend, error) {
+	switch b := WatchBackend(strings.ToLower(backend)); b {
+	case BackendFsnotify, BackendPoll, BackendAuto, BackendRemote:
+		return b, nil
+	case "":
+		return BackendFsnotify, nil
+	}
+
+	return BackendFsnotify, fmt.Errorf("not a valid watch backend: %q", backend)
+}
+
+// DefaultIgnoredFiles match swap, backup and temporary files of common editors
+var DefaultIgnoredFiles = []string{
+	"*.sw?",         // vim swap files
+	"*~",            // vim and emacs backups
+	"4913",          // vim probe file
+	"*___jb_tmp___", // JetBrains safe write
+	"*___jb_old___", // JetBrains safe write
+	"#*#",           // emacs auto save
+	".#*",           // emacs lock files
+}
+
 type ListenerFunc func(event fsnotify.Event)
 
+type WatcherOpts struct {
+	backend        WatchBackend
+	pollInterval   time.Duration
+	pollOnLimit    bool
+	remoteListen   string
+	remoteContents bool
+	followSymlinks bool
+}
+
+// NewWatcherOptions creates watcher options, with pollOnLimit directories that can't be watched
+// because of the inotify watch limit are polled instead of failing. The remote backend listens
+// for agents on remoteListen and writes file contents they send only with remoteContents.
+// With followSymlinks symlinked directories are watched as well.
+func NewWatcherOptions(backend WatchBackend, pollInterval time.Duration, pollOnLimit bool, remoteListen string, remoteContents bool, followSymlinks bool) WatcherOpts {
+	return WatcherOpts{
+		backend:        backend,
+		pollInterval:   pollInterval,
+		pollOnLimit:    pollOnLimit,
+		remoteListen:   remoteListen,
+		remoteContents: remoteContents,
+		followSymlinks: followSymlinks,
+	}
+}
+
 type Watcher struct {
 	sync.Mutex
 	watchDirs     set.Set
 	ignoredDirs   set.Set
 	watchPatterns set.Set
+	ignoredFiles  set.Set
 	file          []string
 	watcher       *fsnotify.Watcher
+	poller        *poller
+	degraded      *poller
+	remote        net.Listener
+	remoteConns   map[net.Conn]bool
+	watchedDirs   map[string]string
+	realDirs      map[string]bool
+	matchingFiles map[string]map[string]bool
+	unwatched     []string
+	options       WatcherOpts
+	probeFile     string
+	probeSeen     chan bool
 	quit          chan bool
 	verbose       bool
 	listeners     []ListenerFunc
 	logger        Logger
 }
 
-func NewWatcher(watchDirs []string, ignoredDirs []string, watchPatterns []string, logger Logger) *Watcher {
+// NewWatcher creates a watcher of files matching watchPatterns, files matching ignoredFiles are skipped even
+// if they match watch patterns. Patterns are matched against the base name of files.
+func NewWatcher(watchDirs []string, ignoredDirs []string, watchPatterns []string, ignoredFiles []string, options WatcherOpts, logger Logger) *Watcher {
 	return &Watcher{
 		watchDirs:     set.NewSet(watchDirs),
 		ignoredDirs:   set.NewSet(ignoredDirs),
 		watchPatterns: set.NewSet(watchPatterns),
+		ignoredFiles:  set.NewSet(ignoredFiles),
+		options:       options,
+		watchedDirs:   make(map[string]string),
+		realDirs:      make(map[string]bool),
+		matchingFiles: make(map[string]map[string]bool),
+		probeSeen:     make(chan bool, 1),
 		verbose:       false,
 		listeners:     make([]ListenerFunc, 0),
 		logger:        logger,
@@ -38,18 +132,28 @@ func NewWatcher(watchDirs []string, ignoredDirs []string, watchPatterns []string
 
 func (w *Watcher) Start() (err error) {
 	w.logger.Debug("Watcher: starting\n")
-	if w.watcher != nil {
+	if w.watcher != nil || w.poller != nil {
 		return errors.New("watcher already started")
 	}
 
+	w.Lock()
+	w.quit = make(chan bool)
+	w.Unlock()
+
+	switch w.options.backend {
+	case BackendPoll:
+		w.startPolling()
+		return nil
+	case BackendRemote:
+		return w.startRemote()
+	}
+
 	w.watcher, err = fsnotify.NewWatcher()
 
 	if err != nil {
 		return err
 	}
 
-	w.quit = make(chan bool)
-
 	for _, dir := range w.watchDirs.Values() {
 		if err := w.AddRecursive(dir); err != nil {
 			_ = w.Stop()
@@ -57,11 +161,113 @@ func (w *Watcher) Start() (err error) {
 		}
 	}
 
+	if err := w.pollUnwatched(); err != nil {
+		_ = w.Stop()
+		return err
+	}
+
 	w.watchLoop()
 
+	if w.options.backend == BackendAuto && !w.probe() {
+		w.logger.Info("Watcher: no file system events received, falling back to polling\n")
+		w.Lock()
+		err := w.watcher.Close()
+		w.watcher = nil
+		w.Unlock()
+		if err != nil {
+			w.logger.Debugf("Watcher: error closing fsnotify watcher: %s\n", err.Error())
+		}
+		w.startPolling()
+	}
+
+	return nil
+}
+
+func (w *Watcher) startPolling() {
+	w.logger.Infof("Watcher: polling for changes every %s\n", w.options.pollInterval)
+	w.Lock()
+	w.poller = newPoller(w, w.options.pollInterval, w.watchDirs.Values(), true)
+	poller, quit := w.poller, w.quit
+	w.Unlock()
+
+	poller.start(quit)
+}
+
+// pollUnwatched polls directories which could not be watched because of the inotify watch limit
+func (w *Watcher) pollUnwatched() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if len(w.unwatched) == 0 {
+		return nil
+	}
+
+	dirs := w.unwatched
+	w.unwatched = nil
+
+	limit := "unknown"
+	suggested := 2 * (len(w.watchedDirs) + len(dirs))
+	if contents, err := ioutil.ReadFile(maxWatchesFile); err == nil {
+		limit = strings.TrimSpace(string(contents))
+		if current, err := strconv.Atoi(limit); err == nil && suggested < 2*current {
+			suggested = 2 * current
+		}
+	}
+
+	w.logger.Infof(
+		"Watcher: inotify watch limit reached, fs.inotify.max_user_watches is %s (shared by all processes of the user), "+
+			"runner needs %d directories watched and %d could not be registered\n",
+		limit, len(w.watchedDirs)+len(dirs), len(dirs),
+	)
+	w.logger.Infof("Watcher: raise the limit with \"sudo sysctl fs.inotify.max_user_watches=%d\"\n", suggested)
+
+	if !w.options.pollOnLimit {
+		return errors.New("inotify watch limit reached")
+	}
+
+	w.logger.Infof("Watcher: continuing in degraded mode, polling %d directories every %s\n", len(dirs), w.options.pollInterval)
+	if w.degraded == nil {
+		w.degraded = newPoller(w, w.options.pollInterval, dirs, false)
+		// the poller dispatches events holding the lock
+		go w.degraded.start(w.quit)
+	} else {
+		go w.degraded.addDirs(dirs)
+	}
+
 	return nil
 }
 
+// probe writes a temporary file into a watched directory and tells whether fsnotify reported it
+func (w *Watcher) probe() bool {
+	dirs := w.watchDirs.Values()
+	if len(dirs) == 0 {
+		return true
+	}
+	sort.Strings(dirs)
+
+	file, err := ioutil.TempFile(dirs[0], ".runner-probe-")
+	if err != nil {
+		w.logger.Debugf("Watcher: unable to write probe file, keeping fsnotify: %s\n", err.Error())
+		return true
+	}
+
+	w.Lock()
+	w.probeFile = filepath.Clean(file.Name())
+	w.Unlock()
+
+	_, _ = file.WriteString("probe")
+	_ = file.Close()
+	defer os.Remove(file.Name())
+
+	select {
+	case <-w.probeSeen:
+		w.logger.Debug("Watcher: probe event received, using fsnotify\n")
+		return true
+	case <-time.After(probeTimeout):
+		return false
+	}
+}
+
 // AddListener adds a listener function to run on event,
 // the listener function will receive the event object as argument.
 func (w *Watcher) AddListener(l ListenerFunc) {
@@ -73,11 +279,23 @@ func (w *Watcher) AddListener(l ListenerFunc) {
 
 func (w *Watcher) Stop() error {
 	w.logger.Debug("Watcher: stopping\n")
+	w.Lock()
+	defer w.Unlock()
+
 	if w.quit != nil {
-		w.quit <- true
 		close(w.quit)
+		w.quit = nil
 	}
 
+	if w.remote != nil {
+		_ = w.remote.Close()
+		w.remote = nil
+	}
+	for conn := range w.remoteConns {
+		_ = conn.Close()
+	}
+	w.remoteConns = nil
+
 	if w.watcher != nil {
 		return w.watcher.Close()
 	}
@@ -85,26 +303,61 @@ func (w *Watcher) Stop() error {
 	return nil
 }
 
+// Reconfigure stops watching, replaces directories, patterns and options and starts watching again.
+// Listeners are kept.
+func (w *Watcher) Reconfigure(watchDirs []string, ignoredDirs []string, watchPatterns []string, ignoredFiles []string, options WatcherOpts) error {
+	if err := w.Stop(); err != nil {
+		w.logger.Debugf("Watcher: error closing fsnotify watcher: %s\n", err.Error())
+	}
+
+	w.Lock()
+	w.watchDirs = set.NewSet(watchDirs)
+	w.ignoredDirs = set.NewSet(ignoredDirs)
+	w.watchPatterns = set.NewSet(watchPatterns)
+	w.ignoredFiles = set.NewSet(ignoredFiles)
+	w.options = options
+	w.watcher = nil
+	w.poller = nil
+	w.degraded = nil
+	w.unwatched = nil
+	w.watchedDirs = make(map[string]string)
+	w.realDirs = make(map[string]bool)
+	w.matchingFiles = make(map[string]map[string]bool)
+	w.Unlock()
+
+	return w.Start()
+}
+
 func (w *Watcher) AddRecursive(dir string) error {
 	w.Lock()
 	defer w.Unlock()
 
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			if len(path) > 1 && strings.HasPrefix(filepath.Base(path), ".") {
-				return filepath.SkipDir
-			}
+	if w.watcher == nil {
+		return nil
+	}
 
-			if w.isIgnoredDir(path) {
-				w.logger.Debugf("Watcher: ignoring \"%s\"\n", path)
+	err := walkTree(dir, w.options.followSymlinks, w.realDirs, func(path string, real string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 
+		if info.IsDir() {
+			if w.skipDir(path) {
+				delete(w.realDirs, real)
 				return filepath.SkipDir
 			}
 
-			w.logger.Debugf("Watcher: watching \"%s\"\n", path)
-			if err := w.watcher.Add(path); err != nil {
+			path = filepath.Clean(path)
+			if err := w.watcher.Add(path); err == syscall.ENOSPC {
+				w.unwatched = append(w.unwatched, path)
+			} else if err != nil {
 				return err
+			} else {
+				w.watchedDirs[path] = real
+				w.logger.Debugf("Watcher: watching \"%s\" (%d directories watched)\n", path, len(w.watchedDirs))
 			}
+		} else if w.fileMatches(&path) {
+			w.trackMatching(path, true)
 		}
 
 		return nil
@@ -125,34 +378,166 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 	defer w.Unlock()
 
 	w.logger.Debugf("Watcher: handling event for %s\n", event)
-	// when new directory is created, add to watch
+	if w.probeFile != "" && filepath.Clean(event.Name) == w.probeFile {
+		select {
+		case w.probeSeen <- true:
+		default:
+		}
+		return
+	}
+
+	// a watched directory was deleted or moved away, files in it are gone as well, a single event is sent
+	// for the whole subtree when it held files matching watch patterns whose removal wasn't reported
+	if _, ok := w.watchedDirs[filepath.Clean(event.Name)]; ok && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		if w.unwatchRecursive(filepath.Clean(event.Name)) {
+			w.logger.Debugf("Watcher: directory with files matching pattern removed \"%s\"\n", event.Name)
+			w.notify(event)
+		}
+		return
+	}
+
+	// when new directory is created or moved into the tree, add to watch
 	if event.Op&fsnotify.Create == fsnotify.Create {
 		info, err := os.Stat(event.Name)
-		if err == nil && info.IsDir() {
-			// noinspection ALL
-			go w.AddRecursive(event.Name)
+		if err == nil && info.IsDir() && !w.skipDir(event.Name) {
+			go w.watchNewDir(event.Name)
+		}
+	}
+
+	w.dispatch(event)
+}
+
+// watchNewDir watches a directory that appeared in the tree along with its subdirectories,
+// then reports files that are already in it
+func (w *Watcher) watchNewDir(dir string) {
+	if err := w.AddRecursive(dir); err != nil {
+		w.logger.Debugf("Watcher: unable to watch \"%s\": %s\n", dir, err.Error())
+	}
+	if err := w.pollUnwatched(); err != nil {
+		w.logger.Infof("Watcher: changes in new directories will not be detected: %s\n", err.Error())
+	}
+	w.rescan(dir)
+}
+
+// unwatchRecursive stops watching the directory and its subdirectories and tells whether they held files
+// matching watch patterns, the caller must hold the lock
+func (w *Watcher) unwatchRecursive(dir string) bool {
+	matching := false
+	prefix := dir + string(filepath.Separator)
+	for path := range w.watchedDirs {
+		if path != dir && !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		matching = matching || len(w.matchingFiles[path]) > 0
+		delete(w.matchingFiles, path)
+		delete(w.realDirs, w.watchedDirs[path])
+		delete(w.watchedDirs, path)
+		// watches of deleted directories are already removed by the kernel
+		if w.watcher != nil {
+			_ = w.watcher.Remove(path)
+		}
+	}
+
+	w.logger.Debugf("Watcher: stopped watching \"%s\" (%d directories watched)\n", dir, len(w.watchedDirs))
+
+	return matching
+}
+
+// rescan reports files of a directory that appeared in the tree, they were either moved in
+// along with the directory or created before the directory was watched
+func (w *Watcher) rescan(dir string) {
+	w.Lock()
+	defer w.Unlock()
+
+	visited := make(map[string]bool)
+	err := walkTree(dir, w.options.followSymlinks, visited, func(path string, real string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if info.IsDir() {
+			if path != dir && w.skipDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
 		}
+
+		w.dispatch(fsnotify.Event{Name: path, Op: fsnotify.Create})
+
+		return nil
+	})
+
+	if err != nil {
+		w.logger.Debugf("Watcher: unable to scan \"%s\": %s\n", dir, err.Error())
 	}
+}
 
+// dispatch notifies listeners when the file matches watch patterns, the caller must hold the lock
+func (w *Watcher) dispatch(event fsnotify.Event) {
 	if w.fileMatches(&event.Name) {
 		w.logger.Debugf("Watcher: file matching pattern \"%s\"\n", event.Name)
+		w.trackMatching(event.Name, event.Op&(fsnotify.Remove|fsnotify.Rename) == 0)
 		w.notify(event)
 	}
 }
 
+// trackMatching records whether the file matching watch patterns exists, so that removing its directory is reported
+// only when the removal of the file wasn't, the caller must hold the lock
+func (w *Watcher) trackMatching(file string, exists bool) {
+	dir, name := filepath.Split(filepath.Clean(file))
+	dir = filepath.Clean(dir)
+
+	if !exists {
+		delete(w.matchingFiles[dir], name)
+		return
+	}
+
+	if w.matchingFiles[dir] == nil {
+		w.matchingFiles[dir] = make(map[string]bool)
+	}
+	w.matchingFiles[dir][name] = true
+}
+
 func (w *Watcher) watchLoop() {
+	w.Lock()
+	events, errs, quit := w.watcher.Events, w.watcher.Errors, w.quit
+	w.Unlock()
 	go func() {
 		for {
 			select {
-			case event := <-w.watcher.Events:
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
 				w.handleEvent(event)
-			case <-w.quit:
+			case err, ok := <-errs:
+				if !ok {
+					return
+				}
+				w.logger.Debugf("Watcher: fsnotify error %s\n", err.Error())
+			case <-quit:
 				return
 			}
 		}
 	}()
 }
 
+// skipDir tells whether the directory and its contents are excluded from watching
+func (w *Watcher) skipDir(path string) bool {
+	if len(path) > 1 && strings.HasPrefix(filepath.Base(path), ".") {
+		return true
+	}
+
+	if w.isIgnoredDir(path) {
+		w.logger.Debugf("Watcher: ignoring \"%s\"\n", path)
+
+		return true
+	}
+
+	return false
+}
+
 func (w *Watcher) isIgnoredDir(path string) bool {
 	paths := strings.Split(path, "/")
 	if len(paths) <= 0 {
@@ -171,6 +556,11 @@ func (w *Watcher) fileMatches(f *string) bool {
 	if f == nil {
 		return true
 	}
+
+	if w.isIgnoredFile(*f) {
+		return false
+	}
+
 	// check exact match
 	match := w.watchPatterns.Has(*f)
 	if match {
@@ -186,3 +576,13 @@ func (w *Watcher) fileMatches(f *string) bool {
 
 	return false
 }
+
+func (w *Watcher) isIgnoredFile(path string) bool {
+	for _, p := range w.ignoredFiles.Values() {
+		if match, _ := filepath.Match(p, filepath.Base(path)); match {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,24 +1,63 @@
 package app
 
 import (
+	"fmt"
 	"github.com/kballard/go-shellquote"
 	"github.com/shirou/gopsutil/process"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// StdinMode defines what the application receives on its standard input.
+type StdinMode string
+
+const (
+	StdinNone    StdinMode = "none"
+	StdinInherit StdinMode = "inherit"
+	StdinFile    StdinMode = "file"
+)
+
+// ParseStdinMode takes a string and returns the stdin mode constant.
+func ParseStdinMode(mode string) (StdinMode, error) {
+	switch m := StdinMode(strings.ToLower(mode)); m {
+	case StdinNone, StdinInherit, StdinFile:
+		return m, nil
+	case "":
+		return StdinNone, nil
+	}
+
+	return StdinNone, fmt.Errorf("not a valid stdin mode: %q", mode)
+}
+
 type Worker struct {
 	command   string
-	arguments []string
+	args      []string
+	dir       string
+	stdin     StdinMode
+	stdinFile string
+	running   int32
+	process   *os.Process
+	exited    chan bool
 	quit      chan bool
 	finished  chan bool
 	logger    Logger
 	appLogger *RunnerOutLog
 }
 
-func NewWorker(command string, logger Logger, appLogger *RunnerOutLog) *Worker {
+// NewWorker creates a worker running the program in dir, current directory when empty.
+// Args start with the program followed by its arguments.
+func NewWorker(args []string, dir string, stdin StdinMode, stdinFile string, logger Logger, appLogger *RunnerOutLog) *Worker {
 	return &Worker{
-		command:   command,
+		command:   shellquote.Join(args...),
+		args:      args,
+		dir:       dir,
+		stdin:     stdin,
+		stdinFile: stdinFile,
+		exited:    make(chan bool),
 		quit:      make(chan bool),
 		finished:  make(chan bool, 1),
 		logger:    logger,
@@ -29,17 +68,22 @@ func NewWorker(command string, logger Logger, appLogger *RunnerOutLog) *Worker {
 func (w *Worker) Run() error {
 	w.logger.Infof("Running %s...\n", w.command)
 
-	parts, err := shellquote.Split(w.command)
-
-	if err != nil {
-		w.logger.Infof("Error parsing command \"%s\": %s\n", w.command, err.Error())
+	if len(w.args) == 0 {
+		w.logger.Info("Error running command: command is empty\n")
 		return nil
 	}
 
-	head := parts[0]
-	parts = parts[1:]
+	cmd := exec.Command(w.args[0], w.args[1:]...)
+	cmd.Dir = w.dir
 
-	cmd := exec.Command(head, parts...)
+	stdin, err := w.openStdin()
+	if err != nil {
+		w.logger.Infof("Cannot open stdin for \"%s\": %s\n", w.command, err.Error())
+		return nil
+	}
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
@@ -53,9 +97,12 @@ func (w *Worker) Run() error {
 
 	err = cmd.Start()
 	if err != nil {
+		w.closeStdin(stdin)
 		w.logger.Infof("Cannot execute command \"%s\": %s", w.command, err.Error())
 		return nil
 	}
+	atomic.StoreInt32(&w.running, 1)
+	w.process = cmd.Process
 	//noinspection ALL
 	go io.Copy(w.appLogger.errWriter, stderr)
 	//noinspection ALL
@@ -65,6 +112,9 @@ func (w *Worker) Run() error {
 		if err := cmd.Wait(); err != nil {
 			w.logger.Debugf("Error while waiting for process to finish: %s", err.Error())
 		}
+		atomic.StoreInt32(&w.running, 0)
+		w.closeStdin(stdin)
+		close(w.exited)
 		w.finished <- true
 	}()
 
@@ -85,11 +135,66 @@ func (w *Worker) Run() error {
 	return nil
 }
 
+// Running tells whether the process is running.
+func (w *Worker) Running() bool {
+	return atomic.LoadInt32(&w.running) == 1
+}
+
+// Pid returns the process id, it's 0 when the process was not started.
+func (w *Worker) Pid() int {
+	if w.process == nil {
+		return 0
+	}
+
+	return w.process.Pid
+}
+
 func (w *Worker) Stop() {
+	if w.process == nil {
+		return
+	}
+
 	w.quit <- true
 	<-w.finished
 }
 
+// Interrupt sends an interrupt signal to the process, which is killed if it doesn't exit within timeout.
+func (w *Worker) Interrupt(timeout time.Duration) {
+	if w.process == nil {
+		return
+	}
+
+	if err := w.process.Signal(os.Interrupt); err != nil {
+		w.logger.Debugf("Error interrupting process %d: %s\n", w.process.Pid, err.Error())
+	}
+
+	select {
+	case <-w.exited:
+	case <-time.After(timeout):
+		w.logger.Debugf("Process %d did not exit after interrupt, killing\n", w.process.Pid)
+	}
+
+	w.Stop()
+}
+
+// openStdin returns the file to attach as stdin of the process, nil means no input
+func (w *Worker) openStdin() (*os.File, error) {
+	switch w.stdin {
+	case StdinInherit:
+		return os.Stdin, nil
+	case StdinFile:
+		return os.Open(w.stdinFile)
+	}
+
+	return nil, nil
+}
+
+func (w *Worker) closeStdin(stdin *os.File) {
+	if stdin != nil && stdin != os.Stdin {
+		_ = stdin.Close()
+	}
+}
+
 func (w *Worker) killChildProcesses(pid int32) error {
 	proc, err := process.NewProcess(pid)
 
@@ -5,14 +5,23 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"time"
 )
 
+// commandTimeout limits how long a client may take to send the command, Stop waits for connections
+// that haven't sent one yet
+const commandTimeout = 2 * time.Second
+
 type ServerHandlerFunc = func(c net.Conn, args []string)
 
 type Server struct {
 	handlers map[string]ServerHandlerFunc
 	port     int
 	socket   net.Listener
+	closing  chan bool
+	accepted chan bool
+	conns    sync.WaitGroup
 }
 
 func NewServer(port int) *Server {
@@ -30,15 +39,27 @@ func (s *Server) Start() error {
 	}
 
 	s.socket = socket
+	s.closing = make(chan bool)
+	s.accepted = make(chan bool)
 
 	go func() {
+		defer close(s.accepted)
 		for {
 			fd, err := socket.Accept()
 			if err != nil {
-				continue
+				select {
+				case <-s.closing:
+					return
+				default:
+					continue
+				}
 			}
 
-			go s.handleConnection(fd)
+			s.conns.Add(1)
+			go func() {
+				defer s.conns.Done()
+				s.handleConnection(fd)
+			}()
 		}
 	}()
 
@@ -52,8 +73,12 @@ func (s *Server) AddHandler(command string, handler ServerHandlerFunc) {
 func (s *Server) handleConnection(c net.Conn) {
 	b := bufio.NewReader(c)
 
+	//noinspection ALL
+	c.SetReadDeadline(time.Now().Add(commandTimeout))
 	line, err := b.ReadBytes('\n')
-	if err != nil { // EOF, or worse
+	if err != nil { // EOF, timeout, or worse
+		//noinspection ALL
+		c.Close()
 		return
 	}
 	// split command into parts, remove last character (new line)
@@ -95,6 +120,12 @@ func (s *Server) handler(command string) ServerHandlerFunc {
 	return nil
 }
 
+// Stop closes the listening socket and waits for connections that are being handled to finish.
 func (s *Server) Stop() error {
-	return s.socket.Close()
+	close(s.closing)
+	err := s.socket.Close()
+	<-s.accepted
+	s.conns.Wait()
+
+	return err
 }
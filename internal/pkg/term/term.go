@@ -0,0 +1,46 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+// Package term provides the minimum of terminal handling runner needs for interactive input.
+package term
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// State holds terminal settings, so they can be restored later.
+type State struct {
+	termios unix.Termios
+}
+
+// IsTerminal tells whether the file descriptor is a terminal.
+func IsTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+
+	return err == nil
+}
+
+// MakeRaw puts the terminal input into raw mode: keys are delivered immediately and are not echoed.
+// Output processing and signal keys are left intact, so logs and Ctrl+C behave as usual.
+func MakeRaw(fd int) (*State, error) {
+	termios, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	old := State{termios: *termios}
+
+	termios.Lflag &^= unix.ICANON | unix.ECHO
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, termios); err != nil {
+		return nil, err
+	}
+
+	return &old, nil
+}
+
+// Restore brings back terminal settings saved by MakeRaw.
+func Restore(fd int, state *State) error {
+	return unix.IoctlSetTermios(fd, ioctlWriteTermios, &state.termios)
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package term
+
+import "errors"
+
+// State holds terminal settings, so they can be restored later.
+type State struct{}
+
+// IsTerminal tells whether the file descriptor is a terminal.
+func IsTerminal(fd int) bool {
+	return false
+}
+
+// MakeRaw is not supported on this platform.
+func MakeRaw(fd int) (*State, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+// Restore is not supported on this platform.
+func Restore(fd int, state *State) error {
+	return errors.New("raw terminal mode is not supported on this platform")
+}